@@ -88,13 +88,13 @@ func (a *Application) Loop(p *Process, args ...interface{}) string {
 		return "failed"
 	}
 
-	p.currentFunction = "Application:Start"
+	p.setCurrentFunction("Application:Start")
 
 	object.(ApplicationBehaviour).Start(p, args[1:]...)
 	lib.Log("Application spec %#v\n", spec)
 	p.ready <- nil
 
-	p.currentFunction = "Application:loop"
+	p.setCurrentFunction("Application:loop")
 
 	if spec.Lifespan == 0 {
 		spec.Lifespan = time.Second * 31536000 * 100 // let's define default lifespan 100 years :)
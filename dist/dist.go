@@ -113,6 +113,9 @@ type Link struct {
 	flags     nodeFlag
 	version   uint16
 
+	// the data received right after the handshake
+	tail []byte
+
 	// writer
 	flusher *linkFlusher
 
@@ -324,15 +327,21 @@ func Handshake(conn net.Conn, tls bool, name, cookie string, hidden bool) (*Link
 
 			case 'a':
 				// 'a' + 16 (digest)
-				if len(buffer) != 17 {
+				if len(buffer) < 17 {
 					return nil, fmt.Errorf("malformed handshake ('a' length of digest)")
 				}
 
 				// 'a' + 16 (digest)
-				if !link.validateChallengeAck(buffer[1:]) {
+				if !link.validateChallengeAck(buffer[1:17]) {
 					return nil, fmt.Errorf("malformed handshake ('a' digest)")
 				}
 
+				if len(buffer) > 17 {
+					// the peer has already started sending the messages.
+					// keep them for the link reader
+					link.tail = append([]byte{}, buffer[17:]...)
+				}
+
 				// handshaked
 				link.flusher = newLinkFlusher(link.conn, defaultLatency)
 				return link, nil
@@ -496,6 +505,11 @@ func (l *Link) Read(b *lib.Buffer) (int, error) {
 	// http://erlang.org/doc/apps/erts/erl_dist_protocol.html#protocol-between-connected-nodes
 	expectingBytes := 4
 
+	if l.tail != nil {
+		b.Append(l.tail)
+		l.tail = nil
+	}
+
 	for {
 		if b.Len() < expectingBytes {
 			n, e := b.ReadDataFrom(l.conn)
@@ -947,7 +961,7 @@ func (l *Link) encodeDistHeaderAtomCache(b *lib.Buffer,
 	}
 }
 
-func (l *Link) Writer(send <-chan []etf.Term, fragmentationUnit int) {
+func (l *Link) Writer(ctx context.Context, send <-chan []etf.Term, fragmentationUnit int) {
 	var terms []etf.Term
 
 	var encodingAtomCache *etf.ListAtomCache
@@ -977,7 +991,11 @@ func (l *Link) Writer(send <-chan []etf.Term, fragmentationUnit int) {
 
 	for {
 		terms = nil
-		terms = <-send
+		select {
+		case terms = <-send:
+		case <-ctx.Done():
+			return
+		}
 
 		if terms == nil {
 			// channel was closed
@@ -1140,6 +1158,12 @@ func (l *Link) GetRemoteName() string {
 	return l.peer.Name
 }
 
+// GetRemoteFlags returns the distribution flags the remote node
+// has reported during the handshake
+func (l *Link) GetRemoteFlags() uint32 {
+	return l.peer.flags.toUint32()
+}
+
 func (l *Link) composeName(b *lib.Buffer, tls bool) {
 	if tls {
 		b.Allocate(11)
@@ -48,12 +48,17 @@ type EPMD struct {
 	Extra    []byte
 	Creation uint16
 
-	staticRoutes map[string]uint16
+	staticRoutes map[string]staticRoute
 	mtx          sync.RWMutex
 
 	response chan interface{}
 }
 
+type staticRoute struct {
+	host string
+	port uint16
+}
+
 func (e *EPMD) Init(ctx context.Context, name string, listenport uint16, epmdport uint16, hidden bool, disableServer bool) {
 	ns := strings.Split(name, "@")
 	if len(ns) != 2 {
@@ -77,7 +82,7 @@ func (e *EPMD) Init(ctx context.Context, name string, listenport uint16, epmdpor
 	e.LowVsn = 5
 	e.Creation = 0
 
-	e.staticRoutes = make(map[string]uint16)
+	e.staticRoutes = make(map[string]staticRoute)
 
 	ready := make(chan bool)
 
@@ -137,13 +142,22 @@ func (e *EPMD) AddStaticRoute(name string, port uint16) error {
 		return err
 	}
 
+	return e.AddStaticRouteHost(name, ns[1], port)
+}
+
+// AddStaticRouteHost adds static route to the node with given name. Unlike
+// AddStaticRoute the host doesn't have to match the host part of the name.
+func (e *EPMD) AddStaticRouteHost(name string, host string, port uint16) error {
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
 	if _, ok := e.staticRoutes[name]; ok {
 		// already exist
 		return fmt.Errorf("already exist")
 	}
-	e.staticRoutes[name] = port
+	e.staticRoutes[name] = staticRoute{
+		host: host,
+		port: port,
+	}
 
 	return nil
 }
@@ -158,9 +172,9 @@ func (e *EPMD) RemoveStaticRoute(name string) {
 func (e *EPMD) ResolvePort(name string) (int, error) {
 	// chech static routes first
 	e.mtx.RLock()
-	if port, ok := e.staticRoutes[name]; ok {
+	if route, ok := e.staticRoutes[name]; ok {
 		e.mtx.RUnlock()
-		return int(port), nil
+		return int(route.port), nil
 	}
 
 	e.mtx.RUnlock()
@@ -168,8 +182,23 @@ func (e *EPMD) ResolvePort(name string) (int, error) {
 	return e.resolvePort(name)
 }
 
+// ResolveStaticRoute returns the address ("host:port") of the static route
+// to the node with given name
+func (e *EPMD) ResolveStaticRoute(name string) (string, bool) {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	route, ok := e.staticRoutes[name]
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(route.host, strconv.Itoa(int(route.port))), true
+}
+
 func (e *EPMD) resolvePort(name string) (int, error) {
 	ns := strings.Split(name, "@")
+	if len(ns) != 2 {
+		return -1, fmt.Errorf("wrong FQDN")
+	}
 	conn, err := net.Dial("tcp", net.JoinHostPort(ns[1], fmt.Sprintf("%d", e.PortEMPD)))
 	if err != nil {
 		return -1, err
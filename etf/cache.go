@@ -101,7 +101,11 @@ func (a *AtomCache) List() [maxCacheItems]Atom {
 }
 
 func (a *AtomCache) ListSince(id int16) []Atom {
-	return a.cacheList[id:]
+	a.Lock()
+	defer a.Unlock()
+	l := make([]Atom, len(a.cacheList)-int(id))
+	copy(l, a.cacheList[id:])
+	return l
 }
 
 func TakeListAtomCache() *ListAtomCache {
@@ -20,10 +20,9 @@ type stackElement struct {
 }
 
 var (
-	termNil = make(List, 0)
+	ErrVersion = fmt.Errorf("Unsupported version of ETF")
 
-	biggestInt = big.NewInt(0xfffffffffffffff)
-	lowestInt  = big.NewInt(-0xfffffffffffffff)
+	termNil = make(List, 0)
 
 	errMalformedAtomUTF8      = fmt.Errorf("Malformed ETF. ettAtomUTF8")
 	errMalformedSmallAtomUTF8 = fmt.Errorf("Malformed ETF. ettSmallAtomUTF8")
@@ -55,6 +54,26 @@ var (
 	errInternal  = fmt.Errorf("Internal error")
 )
 
+// Unmarshal decodes the Erlang External Term Format data (with the version byte 131,
+// as erlang:term_to_binary/1 produces) and stores the result in the value pointed to by dest.
+// If dest is a pointer to the etf.Term (interface{}) the decoded term is stored as is.
+// Otherwise it is transformed using TermIntoStruct. The map with atom (or string) keys
+// is decoded into the struct matching the keys with the 'etf' tags or
+// the field names (case insensitive).
+func Unmarshal(data []byte, dest interface{}) error {
+	if len(data) == 0 || data[0] != ettVersion {
+		return ErrVersion
+	}
+	term, tail, err := Decode(data[1:], nil)
+	if err != nil {
+		return err
+	}
+	if len(tail) > 0 {
+		return errMalformed
+	}
+	return TermIntoStruct(term, dest)
+}
+
 // stackless implementaion is speeding up it up to x25 times
 
 // it might looks hard to understand the logic, but
@@ -189,7 +208,7 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 			packet = packet[4:]
 
 		case ettSmallBig:
-			if len(packet) == 0 {
+			if len(packet) < 2 || len(packet) < int(packet[0])+2 {
 				return nil, nil, errMalformedSmallBig
 			}
 
@@ -211,25 +230,16 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 			}
 			/////
 
-			if len(packet) < int(n+2) {
-				return nil, nil, errMalformedSmallBig
-			}
 			bytes := packet[2 : n+2]
 
-			// encoded as a little endian. convert it to the big endian order
-			l := len(bytes)
-			for i := 0; i < l/2; i++ {
-				bytes[i], bytes[l-1-i] = bytes[l-1-i], bytes[i]
-			}
-
 			bigInt := &big.Int{}
-			bigInt.SetBytes(bytes)
+			bigInt.SetBytes(reverseBytes(bytes))
 			if negative {
 				bigInt = bigInt.Neg(bigInt)
 			}
 
-			// try int and int64
-			if bigInt.Cmp(biggestInt) < 0 && bigInt.Cmp(lowestInt) > 0 {
+			// values within the range of int64 are decoded as int64
+			if bigInt.IsInt64() {
 				term = bigInt.Int64()
 				packet = packet[n+2:]
 				break
@@ -251,14 +261,8 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 			}
 			bytes := packet[5 : n+5]
 
-			// encoded as a little endian. convert it to the big endian order
-			l := len(bytes)
-			for i := 0; i < l/2; i++ {
-				bytes[i], bytes[l-1-i] = bytes[l-1-i], bytes[i]
-			}
-
 			bigInt := &big.Int{}
-			bigInt.SetBytes(bytes)
+			bigInt.SetBytes(reverseBytes(bytes))
 			if negative {
 				bigInt = bigInt.Neg(bigInt)
 			}
@@ -746,3 +750,14 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 
 	return term, packet, nil
 }
+
+// reverseBytes returns a copy of the given little endian number in the big endian order.
+// the packet must be left untouched since it could be decoded again.
+func reverseBytes(b []byte) []byte {
+	l := len(b)
+	r := make([]byte, l)
+	for i := range b {
+		r[l-1-i] = b[i]
+	}
+	return r
+}
@@ -92,11 +92,18 @@ func TestDecodeInteger(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	//-1234567890987654321
-	bigInt := new(big.Int)
-	bigInt.SetString("-1234567890987654321", 10)
+	//-1234567890987654321 is within the range of int64
 	packet = []byte{ettSmallBig, 8, 1, 177, 28, 108, 177, 244, 16, 34, 17}
 	term, _, err = Decode(packet, []Atom{})
+	if err != nil || term != int64(-1234567890987654321) {
+		t.Fatal(err, term)
+	}
+
+	//18446744073709551615 is out of the range of int64
+	bigInt := new(big.Int)
+	bigInt.SetString("18446744073709551615", 10)
+	packet = []byte{ettSmallBig, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255}
+	term, _, err = Decode(packet, []Atom{})
 	if err != nil || bigInt.Cmp(term.(*big.Int)) != 0 {
 		t.Fatal(err, term, bigInt)
 	}
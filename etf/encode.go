@@ -6,6 +6,8 @@ import (
 	"math"
 	"math/big"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/halturin/ergo/lib"
 )
@@ -18,6 +20,28 @@ var (
 	goStruct = byte(242) // internal type
 )
 
+// Marshal returns the Erlang External Term Format encoding of the given term
+// (with the version byte 131, the same as erlang:term_to_binary/1 does).
+// Go structs are encoded as a map with atom keys. The key is the field name or the
+// name from the 'etf' tag (`etf:"name"`). Slices and arrays are encoded as a list,
+// Go maps - as a map, the struct of the registered type (see RegisterType) - as
+// a tagged tuple, pointers are dereferenced (nil pointer is encoded as an empty list).
+// time.Time is encoded as an integer number of nanoseconds since the Unix epoch
+// (zero time is encoded as atom 'undefined').
+func Marshal(term Term) ([]byte, error) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	b.AppendByte(ettVersion)
+	if err := Encode(term, b, nil, nil, nil); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, b.Len())
+	copy(data, b.B)
+	return data, nil
+}
+
 func Encode(term Term, b *lib.Buffer,
 	linkAtomCache *AtomCache,
 	writerAtomCache map[Atom]CacheItem,
@@ -136,8 +160,8 @@ func Encode(term Term, b *lib.Buffer,
 					break
 				}
 
-				// a key (field name)
-				term = Atom(stack.tmp.(func(int) reflect.StructField)(stack.i / 2).Name)
+				// a key (field name or the name from the 'etf' tag)
+				term = Atom(structFieldName(stack.tmp.(func(int) reflect.StructField)(stack.i / 2)))
 
 			default:
 
@@ -332,6 +356,11 @@ func Encode(term Term, b *lib.Buffer,
 			}
 
 		case big.Int:
+			if t.IsInt64() {
+				// Erlang encodes it the same way
+				term = t.Int64()
+				goto recasting
+			}
 			bytes := t.Bytes()
 			negative := t.Sign() < 0
 			l := len(bytes)
@@ -442,6 +471,16 @@ func Encode(term Term, b *lib.Buffer,
 				children: lenTuple,
 			}
 
+		case time.Time:
+			// the number of nanoseconds since the Unix epoch (the same as
+			// erlang:system_time(nanosecond) returns). zero time is 'undefined'
+			if t.IsZero() {
+				term = Atom("undefined")
+			} else {
+				term = t.UnixNano()
+			}
+			goto recasting
+
 		case Pid:
 			b.AppendByte(ettPid)
 			child = &stackElement{
@@ -484,6 +523,11 @@ func Encode(term Term, b *lib.Buffer,
 
 		case List:
 			lenList := len(t)
+			if lenList == 0 {
+				// empty list must be encoded as ettNil
+				b.AppendByte(ettNil)
+				break
+			}
 			buf := b.Extend(5)
 			buf[0] = ettList
 			binary.BigEndian.PutUint32(buf[1:], uint32(lenList))
@@ -505,6 +549,17 @@ func Encode(term Term, b *lib.Buffer,
 			v := reflect.ValueOf(t)
 			switch v.Kind() {
 			case reflect.Struct:
+				if tag, ok := registeredTag(v.Type()); ok {
+					// registered type (see RegisterType)
+					tuple := make(Tuple, v.NumField()+1)
+					tuple[0] = tag
+					for i := 0; i < v.NumField(); i++ {
+						tuple[i+1] = v.Field(i).Interface()
+					}
+					term = tuple
+					goto recasting
+				}
+
 				lenStruct := v.NumField()
 				buf := b.Extend(5)
 				buf[0] = ettMap
@@ -520,6 +575,10 @@ func Encode(term Term, b *lib.Buffer,
 
 			case reflect.Array, reflect.Slice:
 				lenList := v.Len()
+				if lenList == 0 {
+					b.AppendByte(ettNil)
+					break
+				}
 				buf := b.Extend(5)
 				buf[0] = ettList
 				binary.BigEndian.PutUint32(buf[1:], uint32(lenList))
@@ -571,3 +630,11 @@ func Encode(term Term, b *lib.Buffer,
 
 	}
 }
+
+func structFieldName(f reflect.StructField) string {
+	tag := f.Tag.Get("etf")
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
@@ -343,6 +343,33 @@ func TestEncodeList(t *testing.T) {
 	}
 }
 
+func TestEncodeListEmpty(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	expected := []byte{ettSmallTuple, 2, ettNil, ettNil}
+	term := Tuple{List{}, []int{}}
+	err := Encode(term, b, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(b.B, expected) {
+		fmt.Println("exp", expected)
+		fmt.Println("got", b.B)
+		t.Fatal("incorrect value")
+	}
+
+	// must be accepted by the decoder
+	decoded, _, err := Decode(b.B, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, Tuple{List{}, List{}}) {
+		t.Fatal("incorrect decoded value", decoded)
+	}
+}
+
 func TestEncodeSlice(t *testing.T) {
 	b := lib.TakeBuffer()
 	defer lib.ReleaseBuffer(b)
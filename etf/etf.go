@@ -3,8 +3,10 @@ package etf
 import (
 	"fmt"
 	"hash/fnv"
+	"math/big"
 	"reflect"
 	"strings"
+	"time"
 )
 
 type Term interface{}
@@ -50,6 +52,9 @@ type Function struct {
 
 var (
 	hasher32 = fnv.New32a()
+
+	timeType   = reflect.TypeOf(time.Time{})
+	bigIntType = reflect.TypeOf(big.Int{})
 )
 
 func StringTerm(t Term) (s string, ok bool) {
@@ -76,6 +81,8 @@ type Export struct {
 
 // Erlang external term tags.
 const (
+	ettVersion = byte(131)
+
 	ettAtom          = byte(100) //deprecated
 	ettAtomUTF8      = byte(118)
 	ettSmallAtom     = byte(115) //deprecated
@@ -161,6 +168,13 @@ func TermProplistIntoStruct(term Term, dest interface{}) (err error) {
 // given 'dest' (could be a struct, map, slice or array). Its a pretty
 // expencive operation in terms of CPU usage so you shouldn't use it
 // on highload parts of your code. Use manual type casting instead.
+// time.Time value is decoded from the integer number of nanoseconds since
+// the Unix epoch (in UTC) or from atom 'undefined' (zero time). Big integer is
+// decoded into the integer field if it fits, and into the big.Int field as well.
+// Pointer fields are allocated if the term is present (and left nil otherwise).
+// Keys of the map (or the proplist) are also looked up among the fields of
+// the embedded structs. The tuple with the registered tag (see RegisterType) is
+// decoded into the interface{} value as the registered type.
 func TermIntoStruct(term Term, dest interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -194,10 +208,47 @@ func termIntoStruct(term Term, dest reflect.Value) error {
 	}
 
 	if t.Kind() == reflect.Interface {
+		value, registered, err := registeredValue(term)
+		if err != nil {
+			return err
+		}
+		if registered && value.Type().AssignableTo(t) {
+			dest.Set(value)
+			return nil
+		}
+		dest.Set(reflect.ValueOf(term))
+		return nil
+	}
+
+	if t == timeType {
+		return setTimeField(term, dest)
+	}
+	if t == bigIntType {
+		return setBigIntField(term, dest)
+	}
+
+	if reflect.TypeOf(term).AssignableTo(t) {
+		// etf.Tuple into etf.Tuple, etf.Pid into etf.Pid etc.
 		dest.Set(reflect.ValueOf(term))
 		return nil
 	}
 
+	if t.Kind() == reflect.Ptr {
+		if l, ok := term.(List); ok && len(l) == 0 {
+			// nil pointer is encoded as an empty list
+			switch t.Elem().Kind() {
+			case reflect.Slice, reflect.Array:
+			default:
+				dest.Set(reflect.Zero(t))
+				return nil
+			}
+		}
+		if dest.IsNil() {
+			dest.Set(reflect.New(t.Elem()))
+		}
+		return termIntoStruct(term, dest.Elem())
+	}
+
 	switch v := term.(type) {
 	case Atom:
 		dest.SetString(string(v))
@@ -239,6 +290,11 @@ func termIntoStruct(term Term, dest reflect.Value) error {
 		return setUIntField(uint64(v), dest, t)
 	case uint64:
 		return setUIntField(uint64(v), dest, t)
+	case *big.Int:
+		if !v.IsInt64() {
+			return NewInvalidTypesError(t, term)
+		}
+		return setIntField(v.Int64(), dest, t)
 	case Map:
 		return setMapField(v, dest, t)
 	case List:
@@ -311,13 +367,7 @@ func setProplistField(list List, dest reflect.Value) error {
 		if !ok {
 			return &InvalidStructKeyError{Term: key}
 		}
-		index, _ := findStructField(fields, fName)
-		if index == -1 {
-			continue
-		}
-
-		err := termIntoStruct(val, dest.Field(index))
-		if err != nil {
+		if err := setNamedField(dest, fields, fName, val); err != nil {
 			return err
 		}
 	}
@@ -338,13 +388,7 @@ func setProplistElementField(proplist []ProplistElement, dest reflect.Value) err
 		if !ok {
 			return &InvalidStructKeyError{Term: elem.Name}
 		}
-		index, _ := findStructField(fields, fName)
-		if index == -1 {
-			continue
-		}
-
-		err := termIntoStruct(elem.Value, dest.Field(index))
-		if err != nil {
+		if err := setNamedField(dest, fields, fName, elem.Value); err != nil {
 			return err
 		}
 	}
@@ -355,6 +399,8 @@ func setMapField(term Map, dest reflect.Value, t reflect.Type) error {
 	switch t.Kind() {
 	case reflect.Map:
 		return setMapMapField(term, dest, t)
+	case reflect.Struct:
+		return setMapStructField(term, dest)
 	case reflect.Interface:
 		// TODO... do this a better way
 		dest.Set(reflect.ValueOf(term))
@@ -393,17 +439,65 @@ func setMapStructField(term Map, dest reflect.Value) error {
 		if !ok {
 			return &InvalidStructKeyError{Term: key}
 		}
-		index, _ := findStructField(fields, fName)
-		if index == -1 {
-			continue
+		if err := setNamedField(dest, fields, fName, val); err != nil {
+			return err
 		}
+	}
 
-		err := termIntoStruct(val, dest.Field(index))
-		if err != nil {
-			return err
+	return nil
+}
+
+// setNamedField sets the field of the struct 'dest' with the given name. If there is no
+// such field it looks up the fields of the embedded (anonymous) structs allocating
+// the embedded pointer on demand. Unknown names are ignored.
+func setNamedField(dest reflect.Value, fields []reflect.StructField, name string, term Term) error {
+	if index, _ := findStructField(fields, name); index != -1 {
+		return termIntoStruct(term, dest.Field(index))
+	}
+
+	path := findEmbeddedField(dest.Type(), name)
+	if path == nil {
+		return nil
+	}
+	for _, i := range path[:len(path)-1] {
+		dest = dest.Field(i)
+		if dest.Kind() == reflect.Ptr {
+			if dest.IsNil() {
+				dest.Set(reflect.New(dest.Type().Elem()))
+			}
+			dest = dest.Elem()
 		}
 	}
+	return termIntoStruct(term, dest.Field(path[len(path)-1]))
+}
+
+// findEmbeddedField returns the index sequence of the field with the given name
+// promoted from the embedded structs
+func findEmbeddedField(t reflect.Type, key string) []int {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
 
+		fields := make([]reflect.StructField, ft.NumField())
+		for j := range fields {
+			fields[j] = ft.Field(j)
+		}
+		if index, _ := findStructField(fields, key); index != -1 {
+			return []int{i, index}
+		}
+		if path := findEmbeddedField(ft, key); path != nil {
+			return append([]int{i}, path...)
+		}
+	}
 	return nil
 }
 
@@ -447,6 +541,58 @@ func setMapMapField(term Map, dest reflect.Value, t reflect.Type) error {
 	return nil
 }
 
+func setTimeField(term Term, dest reflect.Value) error {
+	var nsec int64
+	switch v := term.(type) {
+	case time.Time:
+		dest.Set(reflect.ValueOf(v))
+		return nil
+	case Atom:
+		if v != "undefined" {
+			return NewInvalidTypesError(dest.Type(), term)
+		}
+		dest.Set(reflect.ValueOf(time.Time{}))
+		return nil
+	case int:
+		nsec = int64(v)
+	case int32:
+		nsec = int64(v)
+	case int64:
+		nsec = v
+	case *big.Int:
+		if !v.IsInt64() {
+			return NewInvalidTypesError(dest.Type(), term)
+		}
+		nsec = v.Int64()
+	default:
+		return NewInvalidTypesError(dest.Type(), term)
+	}
+	dest.Set(reflect.ValueOf(time.Unix(0, nsec).UTC()))
+	return nil
+}
+
+func setBigIntField(term Term, dest reflect.Value) error {
+	value := &big.Int{}
+	switch v := term.(type) {
+	case *big.Int:
+		value.Set(v)
+	case big.Int:
+		value.Set(&v)
+	case int:
+		value.SetInt64(int64(v))
+	case int32:
+		value.SetInt64(int64(v))
+	case int64:
+		value.SetInt64(v)
+	case uint64:
+		value.SetUint64(v)
+	default:
+		return NewInvalidTypesError(dest.Type(), term)
+	}
+	dest.Set(reflect.ValueOf(value).Elem())
+	return nil
+}
+
 func setIntField(i int64, field reflect.Value, t reflect.Type) error {
 	switch t.Kind() {
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
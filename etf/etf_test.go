@@ -2,8 +2,10 @@ package etf
 
 import (
 	"bytes"
+	"math/big"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestTermIntoStruct_Slice(t *testing.T) {
@@ -313,3 +315,245 @@ func TestTermProplistIntoStruct(t *testing.T) {
 		t.Errorf("%#v: got %#v, want %#v", termSliceProplistElements, dest, want)
 	}
 }
+
+func TestMarshalUnmarshal(t *testing.T) {
+	type nested struct {
+		Atom  Atom
+		Tuple Tuple
+	}
+	type testStruct struct {
+		Name    string `etf:"name"`
+		Number  int
+		Float   float64
+		Enabled bool
+		Pid     Pid
+		Ref     Ref
+		List    []nested
+		Map     map[string]int
+		Term    Term
+	}
+
+	value := testStruct{
+		Name:    "hello world",
+		Number:  12345,
+		Float:   3.14,
+		Enabled: true,
+		Pid:     Pid{Node: "node@localhost", ID: 32, Serial: 1, Creation: 2},
+		Ref:     Ref{Node: "node@localhost", Creation: 2, ID: []uint32{73444, 3082813441, 2373634851}},
+		List: []nested{
+			{Atom: "a", Tuple: Tuple{Atom("b"), 1, List{Atom("c")}}},
+			{Atom: "d", Tuple: Tuple{}},
+		},
+		Map:  map[string]int{"a": 1, "b": 2},
+		Term: Tuple{Atom("ok"), Map{Atom("key"): "value"}},
+	}
+
+	data, err := Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 131 {
+		t.Fatal("missing version byte")
+	}
+
+	// struct is encoded as a map, the key is taken from the 'etf' tag
+	var term Term
+	if err := Unmarshal(data, &term); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := term.(Map)[Atom("name")]; !ok {
+		t.Fatal("key 'name' not found", term)
+	}
+
+	var result testStruct
+	if err := Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(value, result) {
+		t.Fatalf("got %#v, want %#v", result, value)
+	}
+
+	if err := Unmarshal(data[1:], &result); err != ErrVersion {
+		t.Fatal("expected ErrVersion, got", err)
+	}
+}
+
+func TestTermIntoStruct_Time(t *testing.T) {
+	type testStruct struct {
+		Created time.Time
+		Updated time.Time
+	}
+
+	now := time.Unix(0, time.Now().UnixNano()).UTC()
+	value := testStruct{
+		Created: now,
+	}
+
+	data, err := Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encoded as the nanoseconds since the Unix epoch or 'undefined' for the zero time
+	var term Term
+	if err := Unmarshal(data, &term); err != nil {
+		t.Fatal(err)
+	}
+	if term.(Map)[Atom("Updated")] != Atom("undefined") {
+		t.Fatal("zero time must be encoded as 'undefined'", term)
+	}
+
+	var result testStruct
+	if err := Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Created.Equal(now) || !result.Updated.IsZero() {
+		t.Fatalf("got %#v, want %#v", result, value)
+	}
+	if !reflect.DeepEqual(result.Created, now) {
+		t.Fatal("round trip is not stable", result.Created, now)
+	}
+
+	var small time.Time
+	if err := TermIntoStruct(int64(1500), &small); err != nil {
+		t.Fatal(err)
+	}
+	if small.UnixNano() != 1500 {
+		t.Fatal("incorrect value", small)
+	}
+	if err := TermIntoStruct(Atom("yesterday"), &small); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTermIntoStruct_EmbeddedAndPointers(t *testing.T) {
+	type Options struct {
+		Timeout int
+		Name    string `etf:"name"`
+	}
+	type inner struct {
+		Pid     *Pid
+		Missing *Pid
+		Options *Options
+	}
+	type outer struct {
+		Options
+		Inner    inner
+		InnerPtr *inner
+		Deleted  *time.Time
+	}
+
+	pid := Pid{Node: "node@localhost", ID: 32, Serial: 1, Creation: 2}
+
+	// fields of the embedded struct are promoted
+	term := Map{
+		Atom("Timeout"): 5,
+		Atom("name"):    "test",
+		Atom("Inner"): Map{
+			Atom("Pid"):     pid,
+			Atom("Options"): Map{Atom("Timeout"): 7},
+		},
+		Atom("InnerPtr"): Map{
+			Atom("Pid"): pid,
+		},
+	}
+	expected := outer{
+		Options: Options{Timeout: 5, Name: "test"},
+		Inner: inner{
+			Pid:     &pid,
+			Options: &Options{Timeout: 7},
+		},
+		InnerPtr: &inner{
+			Pid: &pid,
+		},
+	}
+
+	var result outer
+	if err := TermIntoStruct(term, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("got %#v, want %#v", result, expected)
+	}
+
+	// round trip. nil pointers are encoded as an empty list and decoded back to nil
+	now := time.Unix(0, time.Now().UnixNano()).UTC()
+	expected.Deleted = &now
+	data, err := Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = outer{}
+	if err := Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("got %#v, want %#v", result, expected)
+	}
+	if result.Inner.Missing != nil || result.InnerPtr.Options != nil {
+		t.Fatal("absent pointers must be left nil")
+	}
+
+	// tuple is decoded positionally
+	var tuple inner
+	if err := TermIntoStruct(Tuple{pid, List{}, Tuple{3, "tuple"}}, &tuple); err != nil {
+		t.Fatal(err)
+	}
+	if *tuple.Pid != pid || tuple.Missing != nil || *tuple.Options != (Options{3, "tuple"}) {
+		t.Fatalf("got %#v", tuple)
+	}
+}
+
+func TestMarshalUnmarshal_BigInt(t *testing.T) {
+	values := []string{
+		"0",
+		"1152921504606846975", // 0xfffffffffffffff
+		"1152921504606846976",
+		"9223372036854775807",  // math.MaxInt64
+		"-9223372036854775808", // math.MinInt64
+		"9223372036854775808",
+		"-9223372036854775809",
+		"18446744073709551615", // math.MaxUint64
+		"-9223372036854775807123456789",
+	}
+
+	for _, s := range values {
+		value, _ := new(big.Int).SetString(s, 10)
+		data, err := Marshal(value)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+
+		var term Term
+		if err := Unmarshal(data, &term); err != nil {
+			t.Fatal(s, err)
+		}
+		if value.IsInt64() {
+			// decoded as int64 (or as a smaller int) for the compatibility
+			var i int64
+			if err := TermIntoStruct(term, &i); err != nil || i != value.Int64() {
+				t.Fatal(s, "got", term, err)
+			}
+		} else {
+			b, ok := term.(*big.Int)
+			if !ok || b.Cmp(value) != 0 {
+				t.Fatal(s, "got", term)
+			}
+		}
+
+		var result *big.Int
+		if err := Unmarshal(data, &result); err != nil {
+			t.Fatal(s, err)
+		}
+		if result.Cmp(value) != 0 {
+			t.Fatal(s, "got", result)
+		}
+	}
+
+	// out of range of int64
+	data, _ := Marshal(uint64(18446744073709551615))
+	var i int64
+	if err := Unmarshal(data, &i); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,133 @@
+package etf
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrTypeRegistered  = fmt.Errorf("Tag is already registered with another type")
+	ErrTypeNotStruct   = fmt.Errorf("Prototype must be a struct or a pointer to the struct")
+	ErrTypeNotFound    = fmt.Errorf("Tag is not registered with the given type")
+	ErrTypeUnexported  = fmt.Errorf("Prototype must have exported fields only")
+	errTypeTupleLength = fmt.Errorf("Number of the tuple elements doesn't match the registered type")
+
+	registry = &typeRegistry{
+		tags:  make(map[Atom]reflect.Type),
+		types: make(map[reflect.Type]Atom),
+	}
+)
+
+type typeRegistry struct {
+	// tag => type of the prototype (struct or pointer to the struct)
+	tags map[Atom]reflect.Type
+	// struct type => tag
+	types map[reflect.Type]Atom
+	sync.RWMutex
+}
+
+// RegisterType registers the Go type of the given prototype (struct or pointer to the struct)
+// with the tag. Once registered, the tuple {tag, Field1, Field2, ...} decoded by TermIntoStruct
+// into the interface{} (etf.Term) value is materialized as the registered type (a pointer
+// if the prototype is a pointer), fields are populated in order. The value of this type is
+// encoded as such tuple as well. The tag can't be taken by another type until it's
+// unregistered by the owner type, so the packages can't clobber each other's tags.
+// Registering the same type with the same tag again does nothing. Every field of the
+// type is an element of the tuple, so the type with unexported fields can't be registered.
+func RegisterType(tag Atom, prototype interface{}) error {
+	t := reflect.TypeOf(prototype)
+	st := t
+	if t != nil && t.Kind() == reflect.Ptr {
+		st = t.Elem()
+	}
+	if st == nil || st.Kind() != reflect.Struct {
+		return ErrTypeNotStruct
+	}
+	for i := 0; i < st.NumField(); i++ {
+		if st.Field(i).PkgPath != "" {
+			return ErrTypeUnexported
+		}
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registered, taken := registry.tags[tag]; taken {
+		if registered == t {
+			return nil
+		}
+		return ErrTypeRegistered
+	}
+	if _, taken := registry.types[st]; taken {
+		// the type is registered with another tag
+		return ErrTypeRegistered
+	}
+
+	registry.tags[tag] = t
+	registry.types[st] = tag
+	return nil
+}
+
+// UnregisterType removes the tag registered with the type of the given prototype
+func UnregisterType(tag Atom, prototype interface{}) error {
+	t := reflect.TypeOf(prototype)
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registered, taken := registry.tags[tag]; !taken || registered != t {
+		return ErrTypeNotFound
+	}
+	delete(registry.tags, tag)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	delete(registry.types, t)
+	return nil
+}
+
+// registeredTag returns the tag of the registered struct type
+func registeredTag(t reflect.Type) (Atom, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	tag, ok := registry.types[t]
+	return tag, ok
+}
+
+// registeredValue makes the value of the registered type out of the tagged tuple.
+// returns false if the tuple has no registered tag.
+func registeredValue(term Term) (reflect.Value, bool, error) {
+	tuple, ok := term.(Tuple)
+	if !ok || len(tuple) == 0 {
+		return reflect.Value{}, false, nil
+	}
+	tag, ok := tuple[0].(Atom)
+	if !ok {
+		return reflect.Value{}, false, nil
+	}
+
+	registry.RLock()
+	t, ok := registry.tags[tag]
+	registry.RUnlock()
+	if !ok {
+		return reflect.Value{}, false, nil
+	}
+
+	st := t
+	if t.Kind() == reflect.Ptr {
+		st = t.Elem()
+	}
+	if st.NumField() != len(tuple)-1 {
+		return reflect.Value{}, true, errTypeTupleLength
+	}
+
+	value := reflect.New(st)
+	if err := setStructField(tuple[1:], value.Elem(), st); err != nil {
+		return reflect.Value{}, true, err
+	}
+	if t.Kind() == reflect.Ptr {
+		return value, true, nil
+	}
+	return value.Elem(), true, nil
+}
@@ -0,0 +1,119 @@
+package etf
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testRegisteredValue struct {
+	Name  string
+	Count int
+	Inner Term
+}
+
+type testRegisteredPointer struct {
+	Pid Pid
+}
+
+type testRegisteredUnexported struct {
+	Name  string
+	count int
+}
+
+func TestRegisterType(t *testing.T) {
+	if err := RegisterType("test_value", testRegisteredValue{}); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterType("test_value", testRegisteredValue{})
+	if err := RegisterType("test_pointer", &testRegisteredPointer{}); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterType("test_pointer", &testRegisteredPointer{})
+
+	// registering the same type again does nothing
+	if err := RegisterType("test_value", testRegisteredValue{}); err != nil {
+		t.Fatal(err)
+	}
+	// tag can't be taken by another type
+	if err := RegisterType("test_value", testRegisteredPointer{}); err != ErrTypeRegistered {
+		t.Fatal("expected ErrTypeRegistered, got", err)
+	}
+	if err := UnregisterType("test_value", testRegisteredPointer{}); err != ErrTypeNotFound {
+		t.Fatal("expected ErrTypeNotFound, got", err)
+	}
+	if err := RegisterType("test_int", 1); err != ErrTypeNotStruct {
+		t.Fatal("expected ErrTypeNotStruct, got", err)
+	}
+	// every field must be encoded, so the unexported ones are not allowed
+	if err := RegisterType("test_unexported", testRegisteredUnexported{}); err != ErrTypeUnexported {
+		t.Fatal("expected ErrTypeUnexported, got", err)
+	}
+
+	pid := Pid{Node: "node@localhost", ID: 32, Serial: 1, Creation: 2}
+	term := Tuple{Atom("test_value"), "hello", 5, Tuple{Atom("test_pointer"), pid}}
+	expected := testRegisteredValue{
+		Name:  "hello",
+		Count: 5,
+		Inner: &testRegisteredPointer{Pid: pid},
+	}
+
+	var result Term
+	if err := TermIntoStruct(term, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("got %#v, want %#v", result, expected)
+	}
+
+	// the registered type is encoded as a tagged tuple
+	data, err := Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = nil
+	if err := Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("got %#v, want %#v", result, expected)
+	}
+
+	// unknown tags are left as is
+	raw := Tuple{Atom("test_unknown"), 1}
+	if err := TermIntoStruct(raw, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, raw) {
+		t.Fatalf("got %#v, want %#v", result, raw)
+	}
+
+	// number of the elements must match the type
+	if err := TermIntoStruct(Tuple{Atom("test_pointer"), pid, 1}, &result); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRegisterTypeConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	errors := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errors <- RegisterType("test_concurrent", testRegisteredPointer{})
+			var result Term
+			TermIntoStruct(Tuple{Atom("test_concurrent"), Pid{}}, &result)
+		}()
+	}
+	wg.Wait()
+	close(errors)
+	for err := range errors {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UnregisterType("test_concurrent", testRegisteredPointer{}); err != nil {
+		t.Fatal(err)
+	}
+}
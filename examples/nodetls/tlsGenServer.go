@@ -105,6 +105,8 @@ func main() {
 		// TLSkeyServer: "example.key",
 		// TLScrtClient: "example.crt",
 		// TLSkeyClient: "example.key",
+		// set TLScaCert to verify the certificates of both sides (mutual authentication)
+		// TLScaCert: "ca.crt",
 	}
 
 	// Initialize new node with given name, cookie, listening port range and epmd port
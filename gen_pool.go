@@ -0,0 +1,401 @@
+package ergo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+type GenPoolDispatch = string
+
+const (
+	// GenPoolDispatchRoundRobin messages are dispatched to the workers in turn.
+	// This is the default dispatch strategy.
+	GenPoolDispatchRoundRobin = GenPoolDispatch("round_robin")
+
+	// GenPoolDispatchLeastBusy messages are dispatched to the worker
+	// with the shortest message queue.
+	GenPoolDispatchLeastBusy = GenPoolDispatch("least_busy")
+
+	defaultGenPoolSize          = 3
+	defaultGenPoolFlushInterval = 100 * time.Millisecond
+)
+
+// GenPoolOptions defines the GenPool configuration using InitPool callback.
+type GenPoolOptions struct {
+	// Size number of the workers. Default value is defaultGenPoolSize.
+	Size uint
+
+	// Worker is an object (GenServerBehaviour) every worker is spawned with.
+	// All the workers share this object the same way the children of
+	// the simple_one_for_one supervisor do. Workers are spawned with
+	// DirectDispatch enabled so each of them handles messages one by one.
+	Worker interface{}
+
+	// WorkerArgs the arguments every worker is spawned with.
+	WorkerArgs []interface{}
+
+	// WorkerMailboxSize the size of the mailbox of each worker.
+	// Default value is DefaultProcessMailboxSize.
+	WorkerMailboxSize uint16
+
+	// OverflowSize the number of messages the pool keeps if the mailboxes
+	// of all the workers are full. These messages are dispatched as soon
+	// as any worker has room for them. Messages above this limit are dropped.
+	OverflowSize uint
+
+	// Dispatch dispatch strategy. Default value is GenPoolDispatchRoundRobin.
+	Dispatch GenPoolDispatch
+
+	// RestartIntensity and RestartPeriod limit the restarts of the workers
+	// the same way SupervisorStrategy does. If the workers are restarted more than
+	// RestartIntensity times within RestartPeriod seconds the pool is stopped.
+	// Default values are SupervisorRestartIntensity and SupervisorRestartPeriod.
+	RestartIntensity uint16
+	RestartPeriod    uint16
+}
+
+// GenPoolBehaviour interface for the GenPool implementation
+type GenPoolBehaviour interface {
+	// InitPool
+	InitPool(process *Process, args ...interface{}) GenPoolOptions
+}
+
+// GenPool is implementation of the pool of identical workers. Every message
+// (call, cast or regular one) sent to the pool process is dispatched to one
+// of the workers, so the worker replies to the caller directly. Terminated
+// workers are restarted automatically. The pool process must be spawned
+// with DirectDispatch enabled (see ProcessOptions) so the messages are
+// dispatched in the order they have been received. Otherwise Spawn fails.
+type GenPool struct {
+	GenServer
+}
+
+// GenPoolInfo contains the pool details
+type GenPoolInfo struct {
+	Workers  int
+	Busy     int
+	Idle     int
+	Overflow int
+}
+
+type stateGenPool struct {
+	p              *Process
+	options        GenPoolOptions
+	workers        []*Process
+	next           int
+	overflow       []etf.Term
+	flushScheduled bool
+	restarts       []int64
+}
+
+type genPoolResize struct {
+	size uint
+}
+
+type genPoolInfo struct{}
+
+type genPoolFlush struct{}
+
+// GenPool methods
+
+// Resize changes the number of the workers. The redundant workers
+// are terminated with reason 'shutdown'.
+func (gp *GenPool) Resize(p *Process, size uint) error {
+	if size == 0 {
+		return fmt.Errorf("Pool size can not be zero")
+	}
+	message := genPoolResize{
+		size: size,
+	}
+	_, err := p.Call(p.Self(), message)
+	return err
+}
+
+// Info returns the pool details
+func (gp *GenPool) Info(p *Process) (GenPoolInfo, error) {
+	val, err := p.Call(p.Self(), genPoolInfo{})
+	if err != nil {
+		return GenPoolInfo{}, err
+	}
+	return val.(GenPoolInfo), nil
+}
+
+// GenServer callbacks
+
+func (gp *GenPool) Init(p *Process, args ...interface{}) interface{} {
+	if !p.directDispatch {
+		panic("GenPool: must be spawned with DirectDispatch enabled")
+	}
+
+	state := &stateGenPool{
+		p: p,
+	}
+
+	state.options = p.object.(GenPoolBehaviour).InitPool(p, args...)
+	if state.options.Worker == nil {
+		panic("GenPool: worker is not defined")
+	}
+	if state.options.Size == 0 {
+		state.options.Size = defaultGenPoolSize
+	}
+	if state.options.WorkerMailboxSize == 0 {
+		state.options.WorkerMailboxSize = DefaultProcessMailboxSize
+	}
+	if state.options.Dispatch == "" {
+		state.options.Dispatch = GenPoolDispatchRoundRobin
+	}
+	if state.options.RestartIntensity == 0 {
+		state.options.RestartIntensity = SupervisorRestartIntensity
+	}
+	if state.options.RestartPeriod == 0 {
+		state.options.RestartPeriod = SupervisorRestartPeriod
+	}
+
+	// terminated workers have to be restarted
+	p.SetTrapExit(true)
+	gp.resize(state, state.options.Size)
+
+	return state
+}
+
+func (gp *GenPool) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	st := state.(*stateGenPool)
+
+	switch m := message.(type) {
+	case genPoolResize:
+		gp.resize(st, m.size)
+		return "reply", etf.Atom("ok"), state
+
+	case genPoolInfo:
+		return "reply", gp.info(st), state
+	}
+
+	// the worker replies to the caller directly
+	gp.forward(st, etf.Tuple{etf.Atom("$gen_call"), from, message})
+	return "noreply", nil, state
+}
+
+func (gp *GenPool) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	st := state.(*stateGenPool)
+	gp.forward(st, etf.Tuple{etf.Atom("$gen_cast"), message})
+	return "noreply", state
+}
+
+func (gp *GenPool) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	st := state.(*stateGenPool)
+
+	switch m := message.(type) {
+	case genPoolFlush:
+		st.flushScheduled = false
+		gp.flush(st)
+		return "noreply", state
+
+	case etf.Tuple:
+		// {'EXIT', Pid, Reason}
+		if len(m) == 3 && m.Element(1) == etf.Atom("EXIT") {
+			pid, _ := m.Element(2).(etf.Pid)
+			i := gp.worker(st, pid)
+			if i == -1 {
+				// exit request from the parent or any other linked process
+				reason, ok := m.Element(3).(etf.Atom)
+				if !ok {
+					reason = etf.Atom(fmt.Sprintf("%v", m.Element(3)))
+				}
+				return "stop", string(reason)
+			}
+			if !gp.restart(st, i) {
+				return "stop", "shutdown"
+			}
+			return "noreply", state
+		}
+	}
+
+	gp.forward(st, message)
+	return "noreply", state
+}
+
+func (gp *GenPool) Terminate(reason string, state interface{}) {
+	st := state.(*stateGenPool)
+	for _, worker := range st.workers {
+		if worker == nil || !worker.IsAlive() {
+			continue
+		}
+		worker.Exit(st.p.Self(), "shutdown")
+	}
+}
+
+// private functions
+
+func (gp *GenPool) startWorker(st *stateGenPool) *Process {
+	opts := ProcessOptions{
+		MailboxSize: st.options.WorkerMailboxSize,
+		// the worker handles messages one by one, so its mailbox
+		// reflects how busy it is
+		DirectDispatch: true,
+		// the pool tries another worker if this one is full
+		MailboxOverflow: MailboxOverflowFail,
+		parent:          st.p,
+	}
+	if st.p.groupLeader == nil {
+		opts.GroupLeader = st.p
+	} else {
+		opts.GroupLeader = st.p.groupLeader
+	}
+
+	worker, err := st.p.Node.Spawn("", opts, st.options.Worker, st.options.WorkerArgs...)
+	if err != nil {
+		fmt.Printf("WARNING! GenPool %v can't start worker: %s\n", st.p.Self(), err)
+		return nil
+	}
+	worker.parent = st.p
+	st.p.Link(worker.Self())
+	return worker
+}
+
+func (gp *GenPool) resize(st *stateGenPool, size uint) {
+	for uint(len(st.workers)) < size {
+		st.workers = append(st.workers, gp.startWorker(st))
+	}
+
+	for uint(len(st.workers)) > size {
+		last := len(st.workers) - 1
+		if worker := st.workers[last]; worker != nil {
+			st.p.Unlink(worker.Self())
+			worker.Exit(st.p.Self(), "shutdown")
+		}
+		st.workers = st.workers[:last]
+	}
+
+	st.options.Size = size
+	if st.next >= len(st.workers) {
+		st.next = 0
+	}
+}
+
+// worker returns the index of the worker with the given pid or -1 if there is no such worker
+func (gp *GenPool) worker(st *stateGenPool, pid etf.Pid) int {
+	for i := range st.workers {
+		if st.workers[i] != nil && st.workers[i].Self() == pid {
+			return i
+		}
+	}
+	return -1
+}
+
+// restart restarts the terminated worker. Returns false if the restart intensity is exceeded.
+func (gp *GenPool) restart(st *stateGenPool, i int) bool {
+	st.restarts = append(st.restarts, time.Now().Unix())
+	if len(st.restarts) > int(st.options.RestartIntensity) {
+		period := time.Now().Unix() - st.restarts[0]
+		if period <= int64(st.options.RestartPeriod) {
+			fmt.Printf("ERROR: GenPool %v restart intensity is exceeded (%d restarts for %d seconds)\n",
+				st.p.Self(), st.options.RestartIntensity, st.options.RestartPeriod)
+			st.workers[i] = nil
+			return false
+		}
+		st.restarts = st.restarts[1:]
+	}
+	st.workers[i] = gp.startWorker(st)
+	return true
+}
+
+func (gp *GenPool) info(st *stateGenPool) GenPoolInfo {
+	info := GenPoolInfo{
+		Workers:  len(st.workers),
+		Overflow: len(st.overflow),
+	}
+	for _, worker := range st.workers {
+		if worker == nil {
+			continue
+		}
+		if gp.load(worker) > 0 {
+			info.Busy++
+			continue
+		}
+		info.Idle++
+	}
+	return info
+}
+
+// load returns the number of the messages the worker has to handle
+// including the one it is handling right now.
+func (gp *GenPool) load(worker *Process) int {
+	load := len(worker.mailBox) + len(worker.mailBoxPriority)
+	if worker.getCurrentFunction() != "GenServer:loop" {
+		load++
+	}
+	return load
+}
+
+func (gp *GenPool) forward(st *stateGenPool, message etf.Term) {
+	// keep the order. the overflowed messages must be dispatched first
+	gp.flush(st)
+
+	if len(st.overflow) == 0 && gp.dispatch(st, message) {
+		return
+	}
+
+	if uint(len(st.overflow)) >= st.options.OverflowSize {
+		fmt.Println("WARNING! all the workers of the pool", st.p.Self(), "are busy. dropped message", message)
+		return
+	}
+
+	st.overflow = append(st.overflow, message)
+	if !st.flushScheduled {
+		st.flushScheduled = true
+		st.p.SendAfter(st.p.Self(), genPoolFlush{}, defaultGenPoolFlushInterval)
+	}
+}
+
+func (gp *GenPool) flush(st *stateGenPool) {
+	for len(st.overflow) > 0 {
+		if !gp.dispatch(st, st.overflow[0]) {
+			break
+		}
+		st.overflow = st.overflow[1:]
+	}
+
+	if len(st.overflow) > 0 && !st.flushScheduled {
+		st.flushScheduled = true
+		st.p.SendAfter(st.p.Self(), genPoolFlush{}, defaultGenPoolFlushInterval)
+	}
+}
+
+// dispatch delivers the message to one of the workers.
+// Returns false if the mailboxes of all the workers are full.
+func (gp *GenPool) dispatch(st *stateGenPool, message etf.Term) bool {
+	n := len(st.workers)
+	if n == 0 {
+		return false
+	}
+
+	first := st.next
+	if st.options.Dispatch == GenPoolDispatchLeastBusy {
+		min := -1
+		for i, worker := range st.workers {
+			if worker == nil || !worker.IsAlive() {
+				continue
+			}
+			if load := gp.load(worker); min == -1 || load < min {
+				min = load
+				first = i
+			}
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		k := (first + i) % n
+		worker := st.workers[k]
+		if worker == nil || !worker.IsAlive() {
+			continue
+		}
+		if err := worker.deliver(st.p.Self(), message); err != nil {
+			continue
+		}
+		st.next = (k + 1) % n
+		return true
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+package ergo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+type testGenPool struct {
+	GenPool
+	options GenPoolOptions
+}
+
+func (gp *testGenPool) InitPool(process *Process, args ...interface{}) GenPoolOptions {
+	return gp.options
+}
+
+// the object is shared by all the workers. so keep the pid in the state
+type testGenPoolWorker struct {
+	GenServer
+	v       chan interface{}
+	release chan bool
+}
+
+func (w *testGenPoolWorker) Init(p *Process, args ...interface{}) interface{} {
+	return p.Self()
+}
+func (w *testGenPoolWorker) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", state, state
+}
+func (w *testGenPoolWorker) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	if message == etf.Atom("block") {
+		<-w.release
+		return "noreply", state
+	}
+	w.v <- message
+	return "noreply", state
+}
+func (w *testGenPoolWorker) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	w.v <- message
+	return "noreply", state
+}
+func (w *testGenPoolWorker) Terminate(reason string, state interface{}) {
+}
+
+func TestGenPool(t *testing.T) {
+	fmt.Printf("\n=== Test GenPool\n")
+	fmt.Printf("Starting node: nodeGenPool@localhost: ")
+	node := CreateNode("nodeGenPool@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	worker := &testGenPoolWorker{
+		v:       make(chan interface{}, 10),
+		release: make(chan bool),
+	}
+	pool := &testGenPool{
+		options: GenPoolOptions{
+			Size:   3,
+			Worker: worker,
+		},
+	}
+
+	fmt.Printf("    pool can't be spawned without DirectDispatch: ")
+	if _, err := node.Spawn("pool", ProcessOptions{}, pool); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	p, err := node.Spawn("pool", ProcessOptions{DirectDispatch: true}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller, err := node.Spawn("poolCaller", ProcessOptions{}, &testProcessGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    pool info: ")
+	info, err := pool.Info(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != (GenPoolInfo{Workers: 3, Idle: 3}) {
+		t.Fatal("unexpected info", info)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    calls are dispatched in round robin fashion: ")
+	workers := make(map[etf.Pid]int)
+	for i := 0; i < 6; i++ {
+		pid, err := caller.Call(p.Self(), etf.Atom("whoami"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers[pid.(etf.Pid)]++
+	}
+	if len(workers) != 3 {
+		t.Fatal("unexpected number of workers", workers)
+	}
+	for pid, n := range workers {
+		if n != 2 {
+			t.Fatal("unexpected number of calls", pid, n)
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    terminated worker is restarted: ")
+	for pid := range workers {
+		node.GetProcessByPid(pid).Exit(caller.Self(), "abnormal")
+		break
+	}
+	time.Sleep(100 * time.Millisecond)
+	restarted := false
+	for i := 0; i < 3; i++ {
+		pid, err := caller.Call(p.Self(), etf.Atom("whoami"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := workers[pid.(etf.Pid)]; !ok {
+			restarted = true
+		}
+	}
+	if !restarted {
+		t.Fatal("worker hasn't been restarted")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    resize: ")
+	if err := pool.Resize(p, 5); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := pool.Info(p); info.Workers != 5 {
+		t.Fatal("unexpected info", info)
+	}
+	if err := pool.Resize(p, 1); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := pool.Info(p); info.Workers != 1 {
+		t.Fatal("unexpected info", info)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    messages are dispatched to the workers: ")
+	caller.Send(p.Self(), etf.Atom("info"))
+	waitForResultWithValue(t, worker.v, etf.Atom("info"))
+}
+
+func TestGenPoolOverflow(t *testing.T) {
+	fmt.Printf("\n=== Test GenPool overflow\n")
+	fmt.Printf("Starting node: nodeGenPoolOverflow@localhost: ")
+	node := CreateNode("nodeGenPoolOverflow@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	worker := &testGenPoolWorker{
+		v:       make(chan interface{}, 10),
+		release: make(chan bool),
+	}
+	pool := &testGenPool{
+		options: GenPoolOptions{
+			Size:              2,
+			Worker:            worker,
+			WorkerMailboxSize: 1,
+			OverflowSize:      2,
+			Dispatch:          GenPoolDispatchLeastBusy,
+		},
+	}
+	p, err := node.Spawn("pool", ProcessOptions{DirectDispatch: true}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    least busy worker gets the message: ")
+	p.Cast(p.Self(), etf.Atom("block"))
+	time.Sleep(50 * time.Millisecond)
+	p.Cast(p.Self(), etf.Atom("m1"))
+	waitForResultWithValue(t, worker.v, etf.Atom("m1"))
+
+	fmt.Printf("    messages are kept in the pool if all the workers are busy: ")
+	p.Cast(p.Self(), etf.Atom("block"))
+	time.Sleep(50 * time.Millisecond)
+	for _, m := range []etf.Atom{"m2", "m3", "m4", "m5", "m6"} {
+		p.Cast(p.Self(), m)
+	}
+	info, err := pool.Info(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// m2, m3 are in the mailboxes, m4, m5 are in the pool, m6 is dropped
+	if info != (GenPoolInfo{Workers: 2, Busy: 2, Overflow: 2}) {
+		t.Fatal("unexpected info", info)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    kept messages are dispatched once the workers are released: ")
+	worker.release <- true
+	worker.release <- true
+	waitForResultWithMultiValue(t, worker.v, etf.List{
+		etf.Atom("m2"), etf.Atom("m3"), etf.Atom("m4"), etf.Atom("m5"),
+	})
+	fmt.Println("OK")
+}
+
+// the worker crashes right after the start
+type testGenPoolCrashWorker struct {
+	GenServer
+}
+
+func (w *testGenPoolCrashWorker) Init(p *Process, args ...interface{}) interface{} {
+	p.Send(p.Self(), etf.Atom("crash"))
+	return nil
+}
+func (w *testGenPoolCrashWorker) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (w *testGenPoolCrashWorker) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (w *testGenPoolCrashWorker) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "stop", "crash"
+}
+func (w *testGenPoolCrashWorker) Terminate(reason string, state interface{}) {
+}
+
+func TestGenPoolStop(t *testing.T) {
+	fmt.Printf("\n=== Test GenPool stop\n")
+	fmt.Printf("Starting node: nodeGenPoolStop@localhost: ")
+	node := CreateNode("nodeGenPoolStop@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	worker := &testGenPoolWorker{
+		v:       make(chan interface{}, 10),
+		release: make(chan bool),
+	}
+	pool := &testGenPool{
+		options: GenPoolOptions{
+			Size:   2,
+			Worker: worker,
+		},
+	}
+	p, err := node.Spawn("pool", ProcessOptions{DirectDispatch: true}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller, err := node.Spawn("poolCaller", ProcessOptions{}, &testProcessGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := caller.Call(p.Self(), etf.Atom("whoami"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    exit request stops the pool along with its workers: ")
+	p.Exit(caller.Self(), "shutdown")
+	if err := p.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal("pool is still alive")
+	}
+	if wp := node.GetProcessByPid(w.(etf.Pid)); wp != nil && wp.IsAlive() {
+		t.Fatal("worker is still alive")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    pool is stopped if the restart intensity is exceeded: ")
+	crashPool := &testGenPool{
+		options: GenPoolOptions{
+			Size:             2,
+			Worker:           &testGenPoolCrashWorker{},
+			RestartIntensity: 3,
+			RestartPeriod:    5,
+		},
+	}
+	p, err = node.Spawn("crashPool", ProcessOptions{DirectDispatch: true}, crashPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal("pool is still alive")
+	}
+	fmt.Println("OK")
+}
@@ -3,7 +3,9 @@ package ergo
 import (
 	"fmt"
 	"runtime"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 
 	"github.com/halturin/ergo/etf"
 	"github.com/halturin/ergo/lib"
@@ -23,8 +25,9 @@ type GenServerBehaviour interface {
 	HandleCast(message etf.Term, state interface{}) (string, interface{})
 
 	// HandleCall -> ("reply", message, state) - reply
-	//				 ("noreply", _, state) - noreply
+	//				 ("noreply", _, state) - noreply (reply later using Process.Reply)
 	//		         ("stop", reason, _) - normal stop
+	//		         ("stop_reply", reply, reason) - send reply and stop with reason
 	HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{})
 
 	// HandleInfo -> ("noreply", state) - noreply
@@ -34,6 +37,48 @@ type GenServerBehaviour interface {
 	Terminate(reason string, state interface{})
 }
 
+// GenServerContinue is an optional interface. If the GenServer object implements it,
+// HandleContinue is invoked for every message scheduled via Process.Continue right after
+// the callback (or Init) that has scheduled it and ahead of any message in the mailbox.
+// This is the equivalent of handle_continue in Erlang.
+type GenServerContinue interface {
+	// HandleContinue -> ("noreply", state) - noreply
+	//		             ("stop", reason) - stop with reason
+	HandleContinue(message etf.Term, state interface{}) (string, interface{})
+}
+
+// GenServerStateObserver is an optional interface. If the GenServer object
+// implements it, OnStateChange is invoked every time a callback returns
+// a new state (before the next message is processed). It runs under the same
+// lock as the callbacks so 'old' and 'new' are always consistent.
+type GenServerStateObserver interface {
+	OnStateChange(old, new interface{})
+}
+
+// GenServerCodeChange is an optional interface. If the GenServer object implements it,
+// CodeChange is invoked by Process.TriggerCodeChange in order to migrate the state
+// (like code_change/3 in Erlang). The returned value replaces the current state.
+// Without this callback the state is left unchanged.
+type GenServerCodeChange interface {
+	CodeChange(oldVsn interface{}, extra interface{}, state interface{}) (interface{}, error)
+}
+
+// GenServerDirect is an optional interface. If the GenServer object implements it,
+// HandleDirect serves the requests made by Process.Direct. Otherwise, Process.Direct
+// returns ErrUnsupportedRequest.
+type GenServerDirect interface {
+	HandleDirect(message interface{}) (interface{}, error)
+}
+
+// GenServerPanicHandler is an optional interface. If the GenServer object implements it
+// and the process has been started with ProcessOptions.RecoverFromPanic, HandlePanic is
+// invoked with the recovered value and the stack trace of the panicked callback.
+type GenServerPanicHandler interface {
+	// HandlePanic -> ("noreply", state) - keep running with the given state
+	//		          ("stop", reason) - stop with reason
+	HandlePanic(recovered interface{}, stack []byte, state interface{}) (string, interface{})
+}
+
 // GenServer is implementation of ProcessBehaviour interface for GenServer objects
 type GenServer struct{}
 
@@ -45,175 +90,387 @@ func (gs *GenServer) Loop(p *Process, args ...interface{}) string {
 
 	stop := make(chan string, 2)
 
-	p.currentFunction = "GenServer:loop"
+	if len(p.continuations) > 0 {
+		if p.directDispatch {
+			if reason, stopped := gs.runInline(p, func() bool { return true }, stop); stopped {
+				object.(GenServerBehaviour).Terminate(reason, p.state)
+				return reason
+			}
+		} else {
+			// Init has scheduled continuations. they must be handled ahead of
+			// any message in the mailbox, so we take the lock right here.
+			lockState.Lock()
+			go func() {
+				defer lockState.Unlock()
+				defer gs.panicHandler(p, stop)
+				gs.handleContinue(p, stop)
+			}()
+		}
+	}
+
+	p.setCurrentFunction("GenServer:loop")
 
 	for {
 		var message etf.Term
 		var fromPid etf.Pid
 
 		select {
-		case ex := <-p.gracefulExit:
-			object.(GenServerBehaviour).Terminate(ex.reason, p.state)
-			return ex.reason
-
-		case reason := <-stop:
-			object.(GenServerBehaviour).Terminate(reason, p.state)
-			return reason
-
-		case msg := <-p.mailBox:
+		case msg := <-p.mailBoxPriority:
+			// messages from the priority senders (see SetPrioritySender)
+			// must be handled ahead of the regular ones
 			fromPid = msg.Element(1).(etf.Pid)
 			message = msg.Element(2)
 
-		case <-p.Context.Done():
-			return "kill"
+		default:
+			if dropped := atomic.SwapInt64(&p.dropped, 0); dropped > 0 {
+				// some messages have been dropped due to the mailbox
+				// overflow (see ProcessOptions.MailboxOverflowNotify)
+				fromPid = p.self
+				message = MessageMailboxOverflow{Dropped: int(dropped)}
+				break
+			}
+
+			if len(p.pending) > 0 {
+				// messages have been put aside by the Call made
+				// within the inline callback (DirectDispatch mode)
+				msg := p.pending[0]
+				p.pending = p.pending[1:]
+				fromPid = msg.Element(1).(etf.Pid)
+				message = msg.Element(2)
+				break
+			}
 
-		case direct := <-p.direct:
-			gs.handleDirect(direct)
-			continue
-		}
+			select {
+			case ex := <-p.gracefulExit:
+				object.(GenServerBehaviour).Terminate(ex.reason, p.state)
+				return ex.reason
 
-		lib.Log("[%s]. %v got message from %#v\n", p.Node.FullName, p.self, fromPid)
+			case reason := <-stop:
+				object.(GenServerBehaviour).Terminate(reason, p.state)
+				return reason
 
-		p.reductions++
+			case msg := <-p.mailBoxPriority:
+				fromPid = msg.Element(1).(etf.Pid)
+				message = msg.Element(2)
 
-		panicHandler := func() {
-			if r := recover(); r != nil {
-				pc, fn, line, _ := runtime.Caller(2)
-				fmt.Printf("Warning: GenServer recovered (name: %s) %v %#v at %s[%s:%d]\n",
-					p.Name(), p.self, r, runtime.FuncForPC(pc).Name(), fn, line)
-				stop <- "panic"
+			case msg := <-p.mailBox:
+				fromPid = msg.Element(1).(etf.Pid)
+				message = msg.Element(2)
+
+			case <-p.Context.Done():
+				return "kill"
+
+			case direct := <-p.direct:
+				gs.handleDirect(p, direct, lockState)
+				continue
 			}
 		}
 
+		lib.Log("[%s]. %v got message from %#v\n", p.Node.FullName, p.self, fromPid)
+
+		atomic.AddUint64(&p.reductions, 1)
+
+		var handler func() bool
+
 		switch m := message.(type) {
 		case etf.Tuple:
 			switch mtag := m.Element(1).(type) {
 			case etf.Atom:
 				switch mtag {
 				case etf.Atom("$gen_call"):
-					// We need to wrap it out using goroutine in order to serve
-					// sync-requests (like 'process.Call') within callback execution
-					// since reply (etf.Ref) comes through the same mailBox channel
-					go func() {
-						defer panicHandler()
-
-						lockState.Lock()
-						defer lockState.Unlock()
-
-						fromTuple := m.Element(2).(etf.Tuple)
-
-						cf := p.currentFunction
-						p.currentFunction = "GenServer:HandleCall"
-						code, reply, state := object.(GenServerBehaviour).HandleCall(fromTuple, m.Element(3), p.state)
-						p.currentFunction = cf
-
-						if code == "stop" {
-							stop <- reply.(string)
-							// do not unlock, coz we have to keep this state unchanged for Terminate handler
-							return
-						}
-
-						p.state = state
-
-						if reply != nil && code == "reply" {
-							pid := fromTuple.Element(1).(etf.Pid)
-							ref := fromTuple.Element(2)
-							rep := etf.Term(etf.Tuple{ref, reply})
-							p.Send(pid, rep)
-						}
-					}()
+					handler = func() bool {
+						return gs.handleCall(p, m, stop)
+					}
 
 				case etf.Atom("$gen_cast"):
-					go func() {
-						defer panicHandler()
-
-						lockState.Lock()
-						defer lockState.Unlock()
-
-						cf := p.currentFunction
-						p.currentFunction = "GenServer:HandleCast"
-						code, state := object.(GenServerBehaviour).HandleCast(m.Element(2), p.state)
-						p.currentFunction = cf
-
-						if code == "stop" {
-							stop <- state.(string)
-							return
-						}
-						p.state = state
-					}()
+					handler = func() bool {
+						return gs.handleCast(p, m.Element(2), stop)
+					}
 
 				default:
-					go func() {
-						defer panicHandler()
-
-						lockState.Lock()
-						defer lockState.Unlock()
-
-						cf := p.currentFunction
-						p.currentFunction = "GenServer:HandleInfo"
-						code, state := object.(GenServerBehaviour).HandleInfo(message, p.state)
-						p.currentFunction = cf
-
-						if code == "stop" {
-							stop <- state.(string)
-							return
-						}
-						p.state = state
-					}()
-
+					handler = func() bool {
+						return gs.handleInfo(p, message, stop)
+					}
 				}
 
 			case etf.Ref:
 				lib.Log("got reply: %#v\n%#v", mtag, message)
+				if p.directDispatch {
+					// the callbacks don't read this channel in this mode, so the late
+					// replies (the inline call has timed out) must not block the loop
+					p.putReply(m)
+					continue
+				}
 				p.reply <- m
+				continue
 
 			default:
 				lib.Log("mtag: %#v", mtag)
-				go func() {
-					defer panicHandler()
+				handler = func() bool {
+					return gs.handleInfo(p, message, stop)
+				}
+			}
 
-					lockState.Lock()
-					defer lockState.Unlock()
+		default:
+			lib.Log("m: %#v", m)
+			handler = func() bool {
+				return gs.handleInfo(p, message, stop)
+			}
+		}
 
-					cf := p.currentFunction
-					p.currentFunction = "GenServer:HandleInfo"
-					code, state := object.(GenServerBehaviour).HandleInfo(message, p.state)
-					p.currentFunction = cf
+		if p.directDispatch {
+			if reason, stopped := gs.runInline(p, handler, stop); stopped {
+				object.(GenServerBehaviour).Terminate(reason, p.state)
+				return reason
+			}
+			continue
+		}
 
-					if code == "stop" {
-						stop <- state.(string)
-					}
-					p.state = state
-				}()
+		// We need to wrap it out using goroutine in order to serve
+		// sync-requests (like 'process.Call') within callback execution
+		// since reply (etf.Ref) comes through the same mailBox channel
+		go func() {
+			lockState.Lock()
+			defer lockState.Unlock()
+			// must be deferred after the unlocking since HandlePanic
+			// is invoked within the panic handler
+			defer gs.panicHandler(p, stop)
+
+			if handler() {
+				gs.handleContinue(p, stop)
 			}
+		}()
+	}
+}
 
-		default:
-			lib.Log("m: %#v", m)
-			go func() {
-				defer panicHandler()
+// handleCall invokes HandleCall callback. Returns false if the process is stopping.
+func (gs *GenServer) handleCall(p *Process, m etf.Tuple, stop chan string) bool {
+	fromTuple := m.Element(2).(etf.Tuple)
+
+	cf := p.getCurrentFunction()
+	p.setCurrentFunction("GenServer:HandleCall")
+	code, reply, state := p.object.(GenServerBehaviour).HandleCall(fromTuple, m.Element(3), p.state)
+	p.setCurrentFunction(cf)
+
+	switch code {
+	case "stop":
+		stop <- reply.(string)
+		// do not unlock, coz we have to keep this state unchanged for Terminate handler
+		return false
+	case "stop_reply":
+		// the reply goes ahead of the termination so the caller
+		// gets it before the process is terminated
+		p.Reply(fromTuple, reply)
+		stop <- state.(string)
+		return false
+	}
 
-				lockState.Lock()
-				defer lockState.Unlock()
+	gs.setState(p, state)
+
+	if reply != nil && code == "reply" {
+		p.Reply(fromTuple, reply)
+	}
+	return true
+}
 
-				cf := p.currentFunction
-				p.currentFunction = "GenServer:HandleInfo"
-				code, state := object.(GenServerBehaviour).HandleInfo(message, p.state)
-				p.currentFunction = cf
+// handleCast invokes HandleCast callback. Returns false if the process is stopping.
+func (gs *GenServer) handleCast(p *Process, message etf.Term, stop chan string) bool {
+	cf := p.getCurrentFunction()
+	p.setCurrentFunction("GenServer:HandleCast")
+	code, state := p.object.(GenServerBehaviour).HandleCast(message, p.state)
+	p.setCurrentFunction(cf)
 
-				if code == "stop" {
-					stop <- state.(string)
-					return
-				}
-				p.state = state
-			}()
+	if code == "stop" {
+		stop <- state.(string)
+		return false
+	}
+	gs.setState(p, state)
+	return true
+}
+
+// handleInfo invokes HandleInfo callback. Returns false if the process is stopping.
+func (gs *GenServer) handleInfo(p *Process, message etf.Term, stop chan string) bool {
+	cf := p.getCurrentFunction()
+	p.setCurrentFunction("GenServer:HandleInfo")
+	code, state := p.object.(GenServerBehaviour).HandleInfo(message, p.state)
+	p.setCurrentFunction(cf)
+
+	if code == "stop" {
+		stop <- state.(string)
+		return false
+	}
+	gs.setState(p, state)
+	return true
+}
+
+// handleContinue invokes HandleContinue callback for every continuation
+// scheduled by Process.Continue within the previous callback.
+func (gs *GenServer) handleContinue(p *Process, stop chan string) {
+	for len(p.continuations) > 0 {
+		message := p.continuations[0]
+		p.continuations = p.continuations[1:]
+
+		object, ok := p.object.(GenServerContinue)
+		if !ok {
+			lib.Log("[%s] %v has no HandleContinue callback. continuation is ignored: %#v",
+				p.Node.FullName, p.self, message)
+			continue
+		}
+
+		cf := p.getCurrentFunction()
+		p.setCurrentFunction("GenServer:HandleContinue")
+		code, state := object.HandleContinue(message, p.state)
+		p.setCurrentFunction(cf)
+
+		if code == "stop" {
+			p.continuations = nil
+			stop <- state.(string)
+			return
 		}
+		gs.setState(p, state)
 	}
 }
 
-func (gs *GenServer) handleDirect(m directMessage) {
+// runInline invokes the handler (and the scheduled continuations) within
+// the loop goroutine. Returns the reason if the process has to be stopped.
+func (gs *GenServer) runInline(p *Process, handler func() bool, stop chan string) (string, bool) {
+	func() {
+		defer gs.panicHandler(p, stop)
+		atomic.StoreInt32(&p.inCallback, 1)
+		defer atomic.StoreInt32(&p.inCallback, 0)
 
-	if m.reply != nil {
-		m.err = ErrUnsupportedRequest
+		if handler() {
+			gs.handleContinue(p, stop)
+		}
+	}()
+
+	select {
+	case reason := <-stop:
+		return reason, true
+	default:
+		return "", false
+	}
+}
+
+func (gs *GenServer) panicHandler(p *Process, stop chan string) {
+	if r := recover(); r != nil {
+		pc, fn, line, _ := runtime.Caller(2)
+		fmt.Printf("Warning: GenServer recovered (name: %s) %v %#v at %s[%s:%d]\n",
+			p.Name(), p.self, r, runtime.FuncForPC(pc).Name(), fn, line)
+		p.Node.registerPanic()
+		if p.recoverFromPanic {
+			gs.handlePanic(p, r, debug.Stack(), stop)
+			return
+		}
+		stop <- "panic"
+	}
+}
+
+// handlePanic keeps the process running after the panic (see ProcessOptions.RecoverFromPanic)
+func (gs *GenServer) handlePanic(p *Process, r interface{}, stack []byte, stop chan string) {
+	// the panicked callback has left these ones in the middle
+	p.continuations = nil
+	p.setCurrentFunction("GenServer:loop")
+
+	object, ok := p.object.(GenServerPanicHandler)
+	if !ok {
+		// just keep the current state
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Warning: GenServer HandlePanic panicked (name: %s) %v %#v\n", p.Name(), p.self, r)
+			stop <- "panic"
+		}
+	}()
+
+	code, state := object.HandlePanic(r, stack, p.state)
+	if code == "stop" {
+		stop <- state.(string)
+		return
+	}
+	gs.setState(p, state)
+}
+
+func (gs *GenServer) setState(p *Process, state interface{}) {
+	if observer, ok := p.object.(GenServerStateObserver); ok {
+		observer.OnStateChange(p.state, state)
+	}
+	p.state = state
+}
+
+func (gs *GenServer) handleDirect(p *Process, m directMessage, lockState *sync.Mutex) {
+	switch m.id {
+	case "getStats":
+		m.message = p.stats()
 		m.reply <- m
+
+	case "codeChange":
+		args := m.message.([]interface{})
+		gs.handleDirectLocked(p, m, lockState, func() (interface{}, error) {
+			object, ok := p.object.(GenServerCodeChange)
+			if !ok {
+				return nil, nil
+			}
+
+			cf := p.getCurrentFunction()
+			p.setCurrentFunction("GenServer:CodeChange")
+			state, err := object.CodeChange(args[0], args[1], p.state)
+			p.setCurrentFunction(cf)
+
+			if err != nil {
+				return nil, err
+			}
+			gs.setState(p, state)
+			return nil, nil
+		})
+
+	case "direct":
+		object, ok := p.object.(GenServerDirect)
+		if !ok {
+			m.err = ErrUnsupportedRequest
+			m.reply <- m
+			return
+		}
+		request := m.message
+		gs.handleDirectLocked(p, m, lockState, func() (interface{}, error) {
+			cf := p.getCurrentFunction()
+			p.setCurrentFunction("GenServer:HandleDirect")
+			defer p.setCurrentFunction(cf)
+			return object.HandleDirect(request)
+		})
+
+	default:
+		if m.reply != nil {
+			m.err = ErrUnsupportedRequest
+			m.reply <- m
+		}
+	}
+}
+
+// handleDirectLocked invokes the handler of the direct request under the state lock
+// (the state must not be changed while the callback is running) and sends the reply.
+func (gs *GenServer) handleDirectLocked(p *Process, m directMessage, lockState *sync.Mutex, handler func() (interface{}, error)) {
+	handle := func() {
+		lockState.Lock()
+		defer lockState.Unlock()
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Printf("Warning: GenServer direct request recovered (name: %s) %v %#v\n",
+					p.Name(), p.self, r)
+				m.message = nil
+				m.err = fmt.Errorf("panic")
+			}
+			m.reply <- m
+		}()
+		m.message, m.err = handler()
+	}
+
+	if p.directDispatch {
+		handle()
+		return
 	}
+	// do not block the loop. the running callback might wait for a reply
+	go handle()
 }
@@ -173,6 +173,26 @@ func TestGenServer(t *testing.T) {
 		}
 	}
 
+	fmt.Printf("    process.CallInto (by Name) local (gs1) -> remote (gs3): ")
+	type callReply struct {
+		Name  string
+		Value int
+		Pid   etf.Pid
+	}
+	request := etf.Tuple{"hello", 123, node1gs1.Self()}
+	reply := callReply{}
+	if err := node1gs1.CallInto(processName, request, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != (callReply{"hello", 123, node1gs1.Self()}) {
+		t.Fatal("unexpected reply", reply)
+	}
+	var wrong []int
+	if err := node1gs1.CallInto(processName, request, &wrong); err == nil {
+		t.Fatal("expected decode error")
+	}
+	fmt.Println("OK")
+
 	fmt.Printf("Stopping nodes: %v, %v\n", node1.FullName, node2.FullName)
 	node1.Stop()
 	node2.Stop()
@@ -270,3 +290,682 @@ func waitForTimeout(t *testing.T, w chan interface{}) {
 		return
 	}
 }
+
+type testGenServerStateChange struct {
+	GenServer
+	changes chan interface{}
+}
+
+func (tgs *testGenServerStateChange) Init(p *Process, args ...interface{}) (state interface{}) {
+	return 0
+}
+func (tgs *testGenServerStateChange) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state.(int) + 1
+}
+func (tgs *testGenServerStateChange) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state.(int) + 10
+}
+func (tgs *testGenServerStateChange) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerStateChange) Terminate(reason string, state interface{}) {
+}
+func (tgs *testGenServerStateChange) OnStateChange(old, new interface{}) {
+	tgs.changes <- etf.Tuple{old, new}
+}
+
+func TestGenServerStateChange(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer state change hook\n")
+	fmt.Printf("Starting node: nodeGSStateChange@localhost: ")
+	node := CreateNode("nodeGSStateChange@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs := &testGenServerStateChange{
+		changes: make(chan interface{}, 2),
+	}
+	p, err := node.Spawn("gsStateChange", ProcessOptions{}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    HandleCast returns a new state: ")
+	p.Cast(p.Self(), etf.Atom("inc"))
+	waitForResultWithValue(t, gs.changes, etf.Tuple{0, 1})
+
+	fmt.Printf("    HandleCall returns a new state: ")
+	if _, err := p.Call(p.Self(), etf.Atom("inc")); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gs.changes, etf.Tuple{1, 11})
+
+	fmt.Printf("    HandleInfo returns the same state: ")
+	p.Send(p.Self(), etf.Atom("info"))
+	waitForResultWithValue(t, gs.changes, etf.Tuple{11, 11})
+}
+
+type testGenServerContinue struct {
+	GenServer
+	p *Process
+	v chan interface{}
+}
+
+func (tgs *testGenServerContinue) Init(p *Process, args ...interface{}) (state interface{}) {
+	tgs.p = p
+	p.Continue(etf.Atom("init"))
+	return nil
+}
+func (tgs *testGenServerContinue) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.v <- message
+	tgs.p.Continue(etf.Tuple{etf.Atom("continue"), message})
+	return "noreply", state
+}
+func (tgs *testGenServerContinue) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testGenServerContinue) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testGenServerContinue) HandleContinue(message etf.Term, state interface{}) (string, interface{}) {
+	if message == etf.Atom("init") {
+		// emulate long initialization
+		time.Sleep(100 * time.Millisecond)
+	}
+	tgs.v <- message
+	if reflect.DeepEqual(message, etf.Tuple{etf.Atom("continue"), etf.Atom("stop")}) {
+		return "stop", "normal"
+	}
+	return "noreply", state
+}
+func (tgs *testGenServerContinue) Terminate(reason string, state interface{}) {
+	tgs.v <- reason
+}
+
+func TestGenServerContinue(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer HandleContinue\n")
+	fmt.Printf("Starting node: nodeGSContinue@localhost: ")
+	node := CreateNode("nodeGSContinue@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs := &testGenServerContinue{
+		v: make(chan interface{}, 10),
+	}
+	p, err := node.Spawn("gsContinue", ProcessOptions{}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    continuation of Init is handled ahead of the mailbox: ")
+	p.Send(p.Self(), etf.Atom("info"))
+	waitForResultWithValue(t, gs.v, etf.Atom("init"))
+	fmt.Printf("    message from the mailbox: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("info"))
+
+	fmt.Printf("    continuation of HandleCast: ")
+	p.Cast(p.Self(), etf.Atom("cast"))
+	waitForResultWithValue(t, gs.v, etf.Atom("cast"))
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("continue"), etf.Atom("cast")})
+
+	fmt.Printf("    stop via HandleContinue: ")
+	p.Cast(p.Self(), etf.Atom("stop"))
+	waitForResultWithValue(t, gs.v, etf.Atom("stop"))
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("continue"), etf.Atom("stop")})
+	waitForResultWithValue(t, gs.v, "normal")
+}
+
+type testGenServerDirectDispatch struct {
+	GenServer
+	p *Process
+	v chan interface{}
+}
+
+func (tgs *testGenServerDirectDispatch) Init(p *Process, args ...interface{}) (state interface{}) {
+	tgs.p = p
+	return nil
+}
+func (tgs *testGenServerDirectDispatch) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	switch message {
+	case etf.Atom("slow"):
+		time.Sleep(100 * time.Millisecond)
+	case etf.Atom("call"):
+		// the message below must be handled after this callback
+		tgs.p.Send(tgs.p.Self(), etf.Atom("after call"))
+		reply, err := tgs.p.Call("gsEcho", etf.Atom("echo"))
+		if err != nil {
+			tgs.v <- err
+			return "noreply", state
+		}
+		tgs.v <- reply
+		return "noreply", state
+	case etf.Atom("selfcall"):
+		_, err := tgs.p.Call(tgs.p.Self(), etf.Atom("echo"))
+		tgs.v <- err
+		return "noreply", state
+	case etf.Atom("timeout"):
+		_, err := tgs.p.CallWithTimeout("gsNever", etf.Atom("never"), 1)
+		tgs.v <- err
+		return "noreply", state
+	}
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testGenServerDirectDispatch) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testGenServerDirectDispatch) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testGenServerDirectDispatch) Terminate(reason string, state interface{}) {
+	tgs.v <- reason
+}
+
+func TestGenServerDirectDispatch(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer DirectDispatch\n")
+	fmt.Printf("Starting node: nodeGSDirectDispatch@localhost: ")
+	node := CreateNode("nodeGSDirectDispatch@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	echo := &testGenServer{
+		err: make(chan error, 2),
+	}
+	if _, err := node.Spawn("gsEcho", ProcessOptions{}, echo); err != nil {
+		t.Fatal(err)
+	}
+
+	gs := &testGenServerDirectDispatch{
+		v: make(chan interface{}, 10),
+	}
+	p, err := node.Spawn("gsDirectDispatch", ProcessOptions{DirectDispatch: true}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    slow callback doesn't let the next message jump ahead: ")
+	p.Cast(p.Self(), etf.Atom("slow"))
+	p.Send(p.Self(), etf.Atom("fast"))
+	waitForResultWithValue(t, gs.v, etf.Atom("slow"))
+	fmt.Printf("    next message: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("fast"))
+
+	fmt.Printf("    call within the callback: ")
+	p.Cast(p.Self(), etf.Atom("call"))
+	waitForResultWithValue(t, gs.v, etf.Atom("echo"))
+	fmt.Printf("    message received during the call is kept in order: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("after call"))
+
+	fmt.Printf("    self call within the callback returns ErrSelfCall: ")
+	p.Cast(p.Self(), etf.Atom("selfcall"))
+	waitForResultWithValue(t, gs.v, ErrSelfCall)
+
+	fmt.Printf("    call from outside of the callback: ")
+	if reply, err := p.Call(p.Self(), etf.Atom("ping")); err != nil || reply != etf.Atom("ping") {
+		t.Fatal(reply, err)
+	}
+	fmt.Println("OK")
+
+	if _, err := node.Spawn("gsNever", ProcessOptions{}, &testGenServerDeferredReply{}); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("    call within the callback is timed out: ")
+	p.Cast(p.Self(), etf.Atom("timeout"))
+	select {
+	case v := <-gs.v:
+		if v == nil {
+			t.Fatal("expected timeout")
+		}
+		fmt.Println("OK")
+	case <-time.After(2 * time.Second):
+		t.Fatal("result timeout")
+	}
+
+	fmt.Printf("    late replies don't block the process: ")
+	for i := 0; i < 5; i++ {
+		p.Send(p.Self(), etf.Tuple{node.MakeRef(), etf.Atom("late")})
+	}
+	p.Send(p.Self(), etf.Atom("alive"))
+	waitForResultWithValue(t, gs.v, etf.Atom("alive"))
+	fmt.Printf("    call from outside of the callback after the late replies: ")
+	if reply, err := p.Call(p.Self(), etf.Atom("ping")); err != nil || reply != etf.Atom("ping") {
+		t.Fatal(reply, err)
+	}
+	fmt.Println("OK")
+}
+
+type testGenServerReceiveMatch struct {
+	GenServer
+	p *Process
+	v chan interface{}
+}
+
+func (tgs *testGenServerReceiveMatch) Init(p *Process, args ...interface{}) (state interface{}) {
+	tgs.p = p
+	return nil
+}
+func (tgs *testGenServerReceiveMatch) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	match := func(m etf.Term) bool {
+		return m == etf.Atom("match")
+	}
+	timeout := time.Second
+	if message == etf.Atom("timeout") {
+		timeout = 100 * time.Millisecond
+	}
+	m, err := tgs.p.ReceiveMatch(match, timeout)
+	if err != nil {
+		tgs.v <- err
+		return "noreply", state
+	}
+	tgs.v <- etf.Tuple{etf.Atom("matched"), m}
+	return "noreply", state
+}
+func (tgs *testGenServerReceiveMatch) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testGenServerReceiveMatch) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	if message == etf.Atom("sleep") {
+		// let the next messages arrive before the next callback
+		time.Sleep(50 * time.Millisecond)
+	}
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testGenServerReceiveMatch) Terminate(reason string, state interface{}) {
+}
+
+func TestGenServerReceiveMatch(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer ReceiveMatch\n")
+	fmt.Printf("Starting node: nodeGSReceiveMatch@localhost: ")
+	node := CreateNode("nodeGSReceiveMatch@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs := &testGenServerReceiveMatch{
+		v: make(chan interface{}, 10),
+	}
+	p, err := node.Spawn("gsReceiveMatch", ProcessOptions{DirectDispatch: true}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    matching message is taken: ")
+	p.Cast(p.Self(), etf.Atom("wait"))
+	time.Sleep(50 * time.Millisecond)
+	p.Send(p.Self(), etf.Atom("other"))
+	p.Send(p.Self(), etf.Atom("match"))
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("matched"), etf.Atom("match")})
+	fmt.Printf("    non-matching message is handled afterwards: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("other"))
+
+	fmt.Printf("    preceding message is handled first: ")
+	p.Send(p.Self(), etf.Atom("sleep"))
+	p.Cast(p.Self(), etf.Atom("wait"))
+	p.Send(p.Self(), etf.Atom("other"))
+	p.Send(p.Self(), etf.Atom("match"))
+	waitForResultWithValue(t, gs.v, etf.Atom("sleep"))
+	fmt.Printf("    message received before calling ReceiveMatch is matched: ")
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("matched"), etf.Atom("match")})
+	fmt.Printf("    non-matching message is kept in order: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("other"))
+
+	fmt.Printf("    timeout: ")
+	p.Cast(p.Self(), etf.Atom("timeout"))
+	waitForResultWithValue(t, gs.v, ErrTimeout)
+
+	fmt.Printf("    can't be used without DirectDispatch: ")
+	gs = &testGenServerReceiveMatch{
+		v: make(chan interface{}, 10),
+	}
+	p, err = node.Spawn("gsReceiveMatchNoDirect", ProcessOptions{}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Cast(p.Self(), etf.Atom("wait"))
+	waitForResultWithValue(t, gs.v, ErrNoDirectDispatch)
+}
+
+type testGenServerDeferredReply struct {
+	GenServer
+	p    *Process
+	from etf.Tuple
+}
+
+func (tgs *testGenServerDeferredReply) Init(p *Process, args ...interface{}) (state interface{}) {
+	tgs.p = p
+	return nil
+}
+func (tgs *testGenServerDeferredReply) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerDeferredReply) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	if message == etf.Atom("later") {
+		tgs.from = from
+		tgs.p.SendAfter(tgs.p.Self(), etf.Atom("answer"), 100*time.Millisecond)
+	}
+	// "never" is left unanswered
+	return "noreply", nil, state
+}
+func (tgs *testGenServerDeferredReply) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.p.Reply(tgs.from, etf.Atom("deferred"))
+	return "noreply", state
+}
+func (tgs *testGenServerDeferredReply) Terminate(reason string, state interface{}) {
+}
+
+func TestGenServerDeferredReply(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer deferred reply\n")
+	fmt.Printf("Starting node: nodeGSDeferredReply@localhost: ")
+	node := CreateNode("nodeGSDeferredReply@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	p, err := node.Spawn("gsDeferredReply", ProcessOptions{}, &testGenServerDeferredReply{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller, err := node.Spawn("gsCaller", ProcessOptions{}, &testGenServerDeferredReply{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    reply is sent from HandleInfo: ")
+	reply, err := caller.Call(p.Self(), etf.Atom("later"))
+	if err != nil || reply != etf.Atom("deferred") {
+		t.Fatal(reply, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    unanswered call is timed out: ")
+	if _, err := caller.CallWithTimeout(p.Self(), etf.Atom("never"), 1); err == nil {
+		t.Fatal("expected timeout")
+	}
+	fmt.Println("OK")
+}
+
+type testGenServerCodeChange struct {
+	GenServer
+}
+
+func (tgs *testGenServerCodeChange) Init(p *Process, args ...interface{}) (state interface{}) {
+	return 1
+}
+func (tgs *testGenServerCodeChange) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerCodeChange) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", state, state
+}
+func (tgs *testGenServerCodeChange) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerCodeChange) Terminate(reason string, state interface{}) {
+}
+func (tgs *testGenServerCodeChange) CodeChange(oldVsn interface{}, extra interface{}, state interface{}) (interface{}, error) {
+	if oldVsn != "v1" {
+		return nil, fmt.Errorf("unknown version")
+	}
+	return fmt.Sprintf("%s:%d", extra, state), nil
+}
+
+func TestGenServerCodeChange(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer CodeChange\n")
+	fmt.Printf("Starting node: nodeGSCodeChange@localhost: ")
+	node := CreateNode("nodeGSCodeChange@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	p, err := node.Spawn("gsCodeChange", ProcessOptions{}, &testGenServerCodeChange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    state is migrated: ")
+	if err := p.TriggerCodeChange("v1", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := p.Call(p.Self(), "state"); err != nil || state != "v2:1" {
+		t.Fatal(state, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    error is returned and the state is kept: ")
+	if err := p.TriggerCodeChange("v0", "v2"); err == nil {
+		t.Fatal("expected error")
+	}
+	if state, err := p.Call(p.Self(), "state"); err != nil || state != "v2:1" {
+		t.Fatal(state, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    state is unchanged without CodeChange callback: ")
+	p1, err := node.Spawn("gsNoCodeChange", ProcessOptions{}, &testGenServerContinue{v: make(chan interface{}, 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p1.TriggerCodeChange("v1", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+}
+
+type testGenServerRecoverFromPanic struct {
+	GenServer
+	v chan interface{}
+}
+
+func (tgs *testGenServerRecoverFromPanic) Init(p *Process, args ...interface{}) (state interface{}) {
+	return 0
+}
+func (tgs *testGenServerRecoverFromPanic) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	panic(message)
+}
+func (tgs *testGenServerRecoverFromPanic) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", state, state
+}
+func (tgs *testGenServerRecoverFromPanic) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerRecoverFromPanic) Terminate(reason string, state interface{}) {
+	tgs.v <- reason
+}
+func (tgs *testGenServerRecoverFromPanic) HandlePanic(recovered interface{}, stack []byte, state interface{}) (string, interface{}) {
+	if len(stack) == 0 {
+		tgs.v <- fmt.Errorf("no stack")
+	}
+	tgs.v <- recovered
+	if recovered == etf.Atom("fatal") {
+		return "stop", "fatal"
+	}
+	return "noreply", state.(int) + 1
+}
+
+func TestGenServerRecoverFromPanic(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer RecoverFromPanic\n")
+	fmt.Printf("Starting node: nodeGSRecoverFromPanic@localhost: ")
+	node := CreateNode("nodeGSRecoverFromPanic@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs := &testGenServerRecoverFromPanic{
+		v: make(chan interface{}, 10),
+	}
+	p, err := node.Spawn("gsRecover", ProcessOptions{RecoverFromPanic: true}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    HandlePanic gets the recovered value: ")
+	p.Cast(p.Self(), etf.Atom("boom"))
+	waitForResultWithValue(t, gs.v, etf.Atom("boom"))
+	fmt.Printf("    process keeps running with the new state: ")
+	if state, err := p.Call(p.Self(), "state"); err != nil || state != 1 {
+		t.Fatal(state, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    HandlePanic stops the process: ")
+	p.Cast(p.Self(), etf.Atom("fatal"))
+	waitForResultWithValue(t, gs.v, etf.Atom("fatal"))
+	waitForResultWithValue(t, gs.v, "fatal")
+
+	fmt.Printf("    process without RecoverFromPanic is terminated: ")
+	gs = &testGenServerRecoverFromPanic{
+		v: make(chan interface{}, 10),
+	}
+	p, err = node.Spawn("gsNoRecover", ProcessOptions{}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Cast(p.Self(), etf.Atom("boom"))
+	waitForResultWithValue(t, gs.v, "panic")
+}
+
+type testGenServerStopReply struct {
+	GenServer
+	v chan interface{}
+}
+
+func (tgs *testGenServerStopReply) Init(p *Process, args ...interface{}) (state interface{}) {
+	return nil
+}
+func (tgs *testGenServerStopReply) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerStopReply) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "stop_reply", etf.Atom("bye"), "shutdown"
+}
+func (tgs *testGenServerStopReply) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerStopReply) Terminate(reason string, state interface{}) {
+	tgs.v <- reason
+}
+
+func TestGenServerStopReply(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer stop with reply\n")
+	fmt.Printf("Starting node: nodeGSStopReply@localhost: ")
+	node := CreateNode("nodeGSStopReply@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs := &testGenServerStopReply{
+		v: make(chan interface{}, 2),
+	}
+	p, err := node.Spawn("gsStopReply", ProcessOptions{}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller, err := node.Spawn("gsCaller", ProcessOptions{}, &testGenServerStopReply{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    caller gets the reply: ")
+	if reply, err := caller.Call(p.Self(), etf.Atom("stop")); err != nil || reply != etf.Atom("bye") {
+		t.Fatal(reply, err)
+	}
+	fmt.Println("OK")
+	fmt.Printf("    process is terminated with the given reason: ")
+	waitForResultWithValue(t, gs.v, "shutdown")
+}
+
+type testGenServerDirect struct {
+	GenServer
+}
+
+func (tgs *testGenServerDirect) Init(p *Process, args ...interface{}) (state interface{}) {
+	return nil
+}
+func (tgs *testGenServerDirect) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerDirect) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testGenServerDirect) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testGenServerDirect) Terminate(reason string, state interface{}) {
+}
+func (tgs *testGenServerDirect) HandleDirect(message interface{}) (interface{}, error) {
+	switch message {
+	case "ping":
+		return "pong", nil
+	case "panic":
+		panic(message)
+	}
+	return nil, fmt.Errorf("unknown request")
+}
+
+func TestGenServerDirect(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer HandleDirect\n")
+	fmt.Printf("Starting node: nodeGSDirect@localhost: ")
+	node := CreateNode("nodeGSDirect@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	p, err := node.Spawn("gsDirect", ProcessOptions{}, &testGenServerDirect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    HandleDirect serves the request: ")
+	if reply, err := p.Direct("ping"); err != nil || reply != "pong" {
+		t.Fatal(reply, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    error is returned: ")
+	if _, err := p.Direct("unknown"); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    panic in HandleDirect is returned as an error: ")
+	if _, err := p.Direct("panic"); err == nil {
+		t.Fatal("expected error")
+	}
+	if reply, err := p.Direct("ping"); err != nil || reply != "pong" {
+		t.Fatal(reply, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    ErrUnsupportedRequest without HandleDirect callback: ")
+	p1, err := node.Spawn("gsNoDirect", ProcessOptions{}, &testGenServerStopReply{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p1.Direct("ping"); err != ErrUnsupportedRequest {
+		t.Fatal("expected ErrUnsupportedRequest, got", err)
+	}
+	fmt.Println("OK")
+}
@@ -0,0 +1,442 @@
+package ergo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+type GenTCPPacket = string
+
+const (
+	// GenTCPPacketRaw data is delivered as it has been read from the socket.
+	// This is the default packet framing.
+	GenTCPPacketRaw = GenTCPPacket("raw")
+
+	// GenTCPPacketLine every packet is a line terminated by '\n'.
+	// The line is delivered without the terminator.
+	GenTCPPacketLine = GenTCPPacket("line")
+
+	// GenTCPPacketLength every packet is prefixed by its length
+	// (4 bytes, big-endian). The packet is delivered without the prefix.
+	GenTCPPacketLength = GenTCPPacket("length")
+
+	defaultGenTCPReadBufferSize   = 4096
+	defaultGenTCPShutdownTimeout  = 5 * time.Second
+	defaultGenTCPMaxPacketSize    = 1024 * 1024
+	genTCPPacketLengthHeaderBytes = 4
+)
+
+// GenTCPOptions defines the GenTCP configuration using InitTCP callback.
+type GenTCPOptions struct {
+	// Listen the address to listen on ("host:port")
+	Listen string
+
+	// Packet packet framing. Default value is GenTCPPacketRaw.
+	Packet GenTCPPacket
+
+	// MaxConnections the number of simultaneous connections. Connections above
+	// this limit are closed right after accepting. Zero means unlimited.
+	MaxConnections uint
+
+	// ReadBufferSize the size of the read buffer (and the max size of the line
+	// for GenTCPPacketLine). Default value is defaultGenTCPReadBufferSize.
+	ReadBufferSize int
+
+	// MaxPacketSize the max size of the packet for GenTCPPacketLength. The connection
+	// is closed if it's exceeded. Default value is defaultGenTCPMaxPacketSize.
+	MaxPacketSize uint32
+
+	// ShutdownTimeout how long the acceptor waits for the handlers to finish
+	// their work on termination. Default value is defaultGenTCPShutdownTimeout.
+	ShutdownTimeout time.Duration
+}
+
+// GenTCPBehaviour interface for the GenTCP implementation
+type GenTCPBehaviour interface {
+	// InitTCP
+	InitTCP(process *Process, args ...interface{}) GenTCPOptions
+
+	// HandleConnect this callback is invoked within the handler process once
+	// the connection has been accepted. Returns the state of this connection.
+	HandleConnect(conn *GenTCPConnection) interface{}
+
+	// HandlePacket -> ("noreply", state) - noreply
+	//		           ("stop", reason) - close the connection
+	HandlePacket(conn *GenTCPConnection, packet []byte, state interface{}) (string, interface{})
+
+	// HandleDisconnect this callback is invoked once the connection has been closed
+	HandleDisconnect(conn *GenTCPConnection, state interface{})
+}
+
+// GenTCP is implementation of the TCP acceptor. It listens on the given address and
+// spawns a handler process (linked child of the acceptor) per accepted connection.
+// The callbacks of GenTCPBehaviour are invoked within the handler process of the
+// connection, so the packets of the connection are handled one by one.
+// On termination the acceptor closes the listener and lets the handlers finish
+// the packet they are handling. Stopping the node closes the listener as well and
+// the connections are closed once the handlers have finished their packets.
+type GenTCP struct {
+	GenServer
+}
+
+// GenTCPConnection represents the accepted connection
+type GenTCPConnection struct {
+	net.Conn
+	process *Process
+	packet  GenTCPPacket
+	mutex   sync.Mutex
+}
+
+type stateGenTCP struct {
+	p        *Process
+	options  GenTCPOptions
+	listener net.Listener
+	mutex    sync.Mutex
+	handlers map[etf.Pid]*Process
+	closed   bool
+}
+
+type stateGenTCPHandler struct {
+	behaviour GenTCPBehaviour
+	conn      *GenTCPConnection
+	state     interface{}
+}
+
+type genTCPHandler struct {
+	GenServer
+}
+
+type genTCPAddr struct{}
+
+type genTCPPacket struct {
+	packet []byte
+}
+
+type genTCPClosed struct {
+	err error
+}
+
+// GenTCP methods
+
+// Addr returns the address the acceptor is listening on
+func (gt *GenTCP) Addr(p *Process) (net.Addr, error) {
+	val, err := p.Call(p.Self(), genTCPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	return val.(net.Addr), nil
+}
+
+// GenTCPConnection methods
+
+// Process returns the handler process of this connection
+func (c *GenTCPConnection) Process() *Process {
+	return c.process
+}
+
+// Send sends the packet framing it according to the packet option.
+// It's safe to call it from any goroutine.
+func (c *GenTCPConnection) Send(packet []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch c.packet {
+	case GenTCPPacketLine:
+		packet = append(packet[:len(packet):len(packet)], '\n')
+
+	case GenTCPPacketLength:
+		header := make([]byte, genTCPPacketLengthHeaderBytes)
+		binary.BigEndian.PutUint32(header, uint32(len(packet)))
+		if _, err := c.Write(header); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.Write(packet)
+	return err
+}
+
+// GenServer callbacks
+
+func (gt *GenTCP) Init(p *Process, args ...interface{}) interface{} {
+	state := &stateGenTCP{
+		p:        p,
+		handlers: make(map[etf.Pid]*Process),
+	}
+
+	state.options = p.object.(GenTCPBehaviour).InitTCP(p, args...)
+	if state.options.Packet == "" {
+		state.options.Packet = GenTCPPacketRaw
+	}
+	if state.options.ReadBufferSize == 0 {
+		state.options.ReadBufferSize = defaultGenTCPReadBufferSize
+	}
+	if state.options.MaxPacketSize == 0 {
+		state.options.MaxPacketSize = defaultGenTCPMaxPacketSize
+	}
+	if state.options.ShutdownTimeout == 0 {
+		state.options.ShutdownTimeout = defaultGenTCPShutdownTimeout
+	}
+
+	listener, err := net.Listen("tcp", state.options.Listen)
+	if err != nil {
+		panic(err)
+	}
+	state.listener = listener
+
+	// handlers are linked to the acceptor
+	p.SetTrapExit(true)
+
+	go func() {
+		// node is down or the acceptor is killed
+		<-p.Context.Done()
+		listener.Close()
+	}()
+	go gt.accept(state)
+
+	return state
+}
+
+func (gt *GenTCP) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	st := state.(*stateGenTCP)
+	switch message.(type) {
+	case genTCPAddr:
+		return "reply", st.listener.Addr(), state
+	}
+	return "reply", ErrUnsupportedRequest, state
+}
+
+func (gt *GenTCP) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	fmt.Printf("GenTCP: unhandled message %#v\n", message)
+	return "noreply", state
+}
+
+func (gt *GenTCP) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	st := state.(*stateGenTCP)
+	switch m := message.(type) {
+	case genTCPClosed:
+		// listener has been closed
+		return "stop", m.err.Error()
+
+	case etf.Tuple:
+		// {'EXIT', Pid, Reason}
+		if len(m) == 3 && m.Element(1) == etf.Atom("EXIT") {
+			pid, _ := m.Element(2).(etf.Pid)
+			st.mutex.Lock()
+			_, isHandler := st.handlers[pid]
+			delete(st.handlers, pid)
+			st.mutex.Unlock()
+			if isHandler {
+				// connection is closed
+				return "noreply", state
+			}
+			// exit request from the parent or any other linked process
+			reason, ok := m.Element(3).(etf.Atom)
+			if !ok {
+				reason = etf.Atom(fmt.Sprintf("%v", m.Element(3)))
+			}
+			return "stop", string(reason)
+		}
+	}
+	fmt.Printf("GenTCP: unhandled message %#v\n", message)
+	return "noreply", state
+}
+
+func (gt *GenTCP) Terminate(reason string, state interface{}) {
+	st := state.(*stateGenTCP)
+	st.listener.Close()
+
+	st.mutex.Lock()
+	st.closed = true
+	handlers := make([]*Process, 0, len(st.handlers))
+	for _, handler := range st.handlers {
+		handlers = append(handlers, handler)
+	}
+	st.mutex.Unlock()
+
+	// let the handlers finish the packets they are handling
+	for _, handler := range handlers {
+		handler.Exit(st.p.Self(), "shutdown")
+	}
+	for _, handler := range handlers {
+		handler.WaitWithTimeout(st.options.ShutdownTimeout)
+	}
+}
+
+// default callbacks
+
+func (gt *GenTCP) HandleConnect(conn *GenTCPConnection) interface{} {
+	return nil
+}
+
+func (gt *GenTCP) HandlePacket(conn *GenTCPConnection, packet []byte, state interface{}) (string, interface{}) {
+	fmt.Printf("GenTCP HandlePacket: unhandled packet from %s\n", conn.RemoteAddr())
+	return "noreply", state
+}
+
+func (gt *GenTCP) HandleDisconnect(conn *GenTCPConnection, state interface{}) {
+	return
+}
+
+// private functions
+
+func (gt *GenTCP) accept(st *stateGenTCP) {
+	for {
+		c, err := st.listener.Accept()
+		if err != nil {
+			if st.p.IsAlive() {
+				st.p.Send(st.p.Self(), genTCPClosed{err: err})
+			}
+			return
+		}
+
+		// keep it locked until the handler is registered, so the terminating
+		// acceptor doesn't miss it
+		st.mutex.Lock()
+		n := uint(len(st.handlers))
+		if st.closed || (st.options.MaxConnections > 0 && n >= st.options.MaxConnections) {
+			st.mutex.Unlock()
+			c.Close()
+			continue
+		}
+
+		conn := &GenTCPConnection{
+			Conn:   c,
+			packet: st.options.Packet,
+		}
+		opts := ProcessOptions{
+			// packets of the connection must be handled in order
+			DirectDispatch: true,
+			// slow handler makes the reader wait (back pressure)
+			MailboxOverflow: MailboxOverflowBlock,
+			parent:          st.p,
+		}
+		if st.p.groupLeader == nil {
+			opts.GroupLeader = st.p
+		} else {
+			opts.GroupLeader = st.p.groupLeader
+		}
+
+		handler, err := st.p.Node.Spawn("", opts, &genTCPHandler{}, st.p.object, conn)
+		if err != nil {
+			st.mutex.Unlock()
+			fmt.Printf("WARNING! GenTCP %v can't start handler: %s\n", st.p.Self(), err)
+			c.Close()
+			continue
+		}
+		handler.parent = st.p
+		st.p.Link(handler.Self())
+		st.handlers[handler.Self()] = handler
+		st.mutex.Unlock()
+
+		go gt.read(st.options, conn, handler)
+	}
+}
+
+// read reads the packets and delivers them to the handler process
+// until the connection is closed.
+func (gt *GenTCP) read(options GenTCPOptions, conn *GenTCPConnection, handler *Process) {
+	go func() {
+		// the handler is killed (or the node is stopped). close the connection
+		// once it has finished the packet it is handling
+		handler.Wait()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReaderSize(conn, options.ReadBufferSize)
+	for {
+		var packet []byte
+		var err error
+
+		switch options.Packet {
+		case GenTCPPacketLine:
+			var line []byte
+			line, err = reader.ReadSlice('\n')
+			if err == bufio.ErrBufferFull {
+				err = fmt.Errorf("line is too long")
+				break
+			}
+			if err == nil {
+				packet = make([]byte, len(line)-1)
+				copy(packet, line)
+			}
+
+		case GenTCPPacketLength:
+			header := make([]byte, genTCPPacketLengthHeaderBytes)
+			if _, err = io.ReadFull(reader, header); err != nil {
+				break
+			}
+			length := binary.BigEndian.Uint32(header)
+			if length > options.MaxPacketSize {
+				err = fmt.Errorf("packet is too large")
+				break
+			}
+			packet = make([]byte, length)
+			_, err = io.ReadFull(reader, packet)
+
+		default:
+			buf := make([]byte, options.ReadBufferSize)
+			var n int
+			n, err = reader.Read(buf)
+			packet = buf[:n]
+			if n > 0 && err != nil {
+				// the data has been read along with the error
+				handler.Send(handler.Self(), genTCPPacket{packet: packet})
+			}
+		}
+
+		if err != nil {
+			handler.Send(handler.Self(), genTCPClosed{err: err})
+			return
+		}
+		handler.Send(handler.Self(), genTCPPacket{packet: packet})
+	}
+}
+
+func (h *genTCPHandler) Init(p *Process, args ...interface{}) interface{} {
+	state := &stateGenTCPHandler{
+		behaviour: args[0].(GenTCPBehaviour),
+		conn:      args[1].(*GenTCPConnection),
+	}
+	state.conn.process = p
+	state.state = state.behaviour.HandleConnect(state.conn)
+	return state
+}
+
+func (h *genTCPHandler) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", ErrUnsupportedRequest, state
+}
+
+func (h *genTCPHandler) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+func (h *genTCPHandler) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	st := state.(*stateGenTCPHandler)
+
+	switch m := message.(type) {
+	case genTCPPacket:
+		code, s := st.behaviour.HandlePacket(st.conn, m.packet, st.state)
+		if code == "stop" {
+			return "stop", s
+		}
+		st.state = s
+
+	case genTCPClosed:
+		return "stop", "normal"
+	}
+	return "noreply", state
+}
+
+func (h *genTCPHandler) Terminate(reason string, state interface{}) {
+	st := state.(*stateGenTCPHandler)
+	st.conn.Close()
+	st.behaviour.HandleDisconnect(st.conn, st.state)
+}
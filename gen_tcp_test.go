@@ -0,0 +1,325 @@
+package ergo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type testGenTCP struct {
+	GenTCP
+	options GenTCPOptions
+	v       chan interface{}
+}
+
+func (gt *testGenTCP) InitTCP(process *Process, args ...interface{}) GenTCPOptions {
+	return gt.options
+}
+
+func (gt *testGenTCP) HandleConnect(conn *GenTCPConnection) interface{} {
+	gt.v <- "connect"
+	return 0
+}
+
+func (gt *testGenTCP) HandlePacket(conn *GenTCPConnection, packet []byte, state interface{}) (string, interface{}) {
+	switch string(packet) {
+	case "stop":
+		return "stop", "normal"
+	case "slow":
+		gt.v <- "slow"
+		time.Sleep(300 * time.Millisecond)
+	}
+	n := state.(int) + 1
+	conn.Send([]byte(fmt.Sprintf("%d:%s", n, packet)))
+	return "noreply", n
+}
+
+func (gt *testGenTCP) HandleDisconnect(conn *GenTCPConnection, state interface{}) {
+	gt.v <- "disconnect"
+}
+
+// testGenTCPConn returns the data along with io.EOF
+type testGenTCPConn struct {
+	net.Conn
+	data    []byte
+	written chan []byte
+}
+
+func (c *testGenTCPConn) Read(b []byte) (int, error) {
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	return n, io.EOF
+}
+
+func (c *testGenTCPConn) Write(b []byte) (int, error) {
+	c.written <- b
+	return len(b), nil
+}
+
+func (c *testGenTCPConn) Close() error {
+	return nil
+}
+
+func TestGenTCP(t *testing.T) {
+	fmt.Printf("\n=== Test GenTCP\n")
+	fmt.Printf("Starting node: nodeGenTCP@localhost: ")
+	node := CreateNode("nodeGenTCP@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gt := &testGenTCP{
+		options: GenTCPOptions{
+			Listen:         "127.0.0.1:0",
+			Packet:         GenTCPPacketLine,
+			MaxConnections: 1,
+		},
+		v: make(chan interface{}, 10),
+	}
+	p, err := node.Spawn("tcp", ProcessOptions{}, gt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := gt.Addr(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    connection is accepted: ")
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForResultWithValue(t, gt.v, "connect")
+
+	fmt.Printf("    packets are handled in order: ")
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("hello\nworld\n"))
+	for _, expected := range []string{"1:hello\n", "2:world\n"} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != expected {
+			t.Fatalf("expected %q, got %q", expected, line)
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connection above MaxConnections is closed: ")
+	conn1, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn1.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn1.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected EOF, got", err)
+	}
+	conn1.Close()
+	fmt.Println("OK")
+
+	fmt.Printf("    HandlePacket closes the connection: ")
+	conn.Write([]byte("stop\n"))
+	waitForResultWithValue(t, gt.v, "disconnect")
+
+	fmt.Printf("    client closes the connection: ")
+	// the handler is removed asynchronously
+	time.Sleep(50 * time.Millisecond)
+	conn2, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gt.v, "connect")
+	conn2.Close()
+	fmt.Printf("    HandleDisconnect: ")
+	waitForResultWithValue(t, gt.v, "disconnect")
+
+	fmt.Printf("    acceptor termination closes the connections: ")
+	time.Sleep(50 * time.Millisecond)
+	conn3, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn3.Close()
+	waitForResultWithValue(t, gt.v, "connect")
+	p.Exit(p.Self(), "normal")
+	waitForResultWithValue(t, gt.v, "disconnect")
+	fmt.Printf("    listener is closed: ")
+	if err := p.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", addr.String()); err == nil {
+		t.Fatal("listener is still open")
+	}
+	fmt.Println("OK")
+}
+
+func TestGenTCPPacketLength(t *testing.T) {
+	fmt.Printf("\n=== Test GenTCP length-prefixed packets\n")
+	fmt.Printf("Starting node: nodeGenTCPLength@localhost: ")
+	node := CreateNode("nodeGenTCPLength@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gt := &testGenTCP{
+		options: GenTCPOptions{
+			Listen: "127.0.0.1:0",
+			Packet: GenTCPPacketLength,
+		},
+		v: make(chan interface{}, 10),
+	}
+	p, err := node.Spawn("tcp", ProcessOptions{}, gt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := gt.Addr(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    connection is accepted: ")
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForResultWithValue(t, gt.v, "connect")
+
+	fmt.Printf("    packet is framed by its length: ")
+	packet := []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}
+	// split it to make sure it's reassembled
+	conn.Write(packet[:3])
+	time.Sleep(10 * time.Millisecond)
+	conn.Write(packet[3:])
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "1:hello" {
+		t.Fatalf("expected %q, got %q", "1:hello", reply)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    node stop closes the connection: ")
+	node.Stop()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected EOF, got", err)
+	}
+	fmt.Println("OK")
+}
+
+func TestGenTCPHandlers(t *testing.T) {
+	fmt.Printf("\n=== Test GenTCP handlers\n")
+	fmt.Printf("Starting node: nodeGenTCPHandlers@localhost: ")
+	node := CreateNode("nodeGenTCPHandlers@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gt := &testGenTCP{
+		options: GenTCPOptions{
+			Listen: "127.0.0.1:0",
+			Packet: GenTCPPacketLine,
+		},
+		v: make(chan interface{}, 10),
+	}
+
+	fmt.Printf("    raw data read along with EOF is delivered: ")
+	conn := &GenTCPConnection{
+		Conn: &testGenTCPConn{
+			data:    []byte("hello"),
+			written: make(chan []byte, 10),
+		},
+		packet: GenTCPPacketRaw,
+	}
+	handler, err := node.Spawn("", ProcessOptions{DirectDispatch: true}, &genTCPHandler{}, gt, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gt.v, "connect")
+	go gt.read(GenTCPOptions{Packet: GenTCPPacketRaw, ReadBufferSize: 16}, conn, handler)
+	select {
+	case written := <-conn.Conn.(*testGenTCPConn).written:
+		if string(written) != "1:hello" {
+			t.Fatalf("expected %q, got %q", "1:hello", written)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("packet is lost")
+	}
+	fmt.Println("OK")
+	fmt.Printf("    HandleDisconnect: ")
+	waitForResultWithValue(t, gt.v, "disconnect")
+
+	fmt.Printf("    killed acceptor closes the connections: ")
+	p, err := node.Spawn("", ProcessOptions{}, gt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := gt.Addr(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn1, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+	waitForResultWithValue(t, gt.v, "connect")
+	p.Kill()
+	conn1.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn1.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected EOF, got", err)
+	}
+	fmt.Println("OK")
+	// drain the "disconnect" if the handler has been terminated gracefully
+	select {
+	case <-gt.v:
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	fmt.Printf("    node stop lets the handler finish the packet: ")
+	p, err = node.Spawn("", ProcessOptions{}, gt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err = gt.Addr(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	waitForResultWithValue(t, gt.v, "connect")
+	conn2.Write([]byte("slow\n"))
+	waitForResultWithValue(t, gt.v, "slow")
+	node.Stop()
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(conn2).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "1:slow\n" {
+		t.Fatalf("expected %q, got %q", "1:slow\n", line)
+	}
+	fmt.Println("OK")
+}
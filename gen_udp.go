@@ -0,0 +1,303 @@
+package ergo
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+const (
+	defaultGenUDPReadBufferSize  = 65535
+	defaultGenUDPWorkers         = 1
+	defaultGenUDPShutdownTimeout = 5 * time.Second
+)
+
+// GenUDPOptions defines the GenUDP configuration using InitUDP callback.
+type GenUDPOptions struct {
+	// Listen the address to bind to ("host:port"). Both IPv4 ("127.0.0.1:514")
+	// and IPv6 ("[::1]:514") addresses are supported.
+	Listen string
+
+	// ReadBufferSize the max size of the datagram. Datagrams above this size
+	// are truncated. Default value is defaultGenUDPReadBufferSize.
+	ReadBufferSize int
+
+	// Workers the number of the worker processes handling datagrams
+	// concurrently. Default value is defaultGenUDPWorkers.
+	Workers uint
+
+	// ShutdownTimeout how long the process waits for the workers to finish
+	// their work on termination. Default value is defaultGenUDPShutdownTimeout.
+	ShutdownTimeout time.Duration
+}
+
+// GenUDPBehaviour interface for the GenUDP implementation
+type GenUDPBehaviour interface {
+	// InitUDP
+	InitUDP(process *Process, args ...interface{}) GenUDPOptions
+
+	// HandlePacket this callback is invoked within the worker process for
+	// every received datagram. Each worker handles datagrams one by one, so
+	// it's invoked concurrently if Workers > 1. The panic is recovered
+	// and the datagram is discarded.
+	HandlePacket(socket *GenUDPSocket, addr net.Addr, packet []byte)
+}
+
+// GenUDP is implementation of the datagram server. It binds the socket and
+// spawns the worker processes (linked children of the GenUDP process). Every
+// received datagram is delivered to the mailbox of the worker, which invokes
+// the HandlePacket callback. The socket is closed on termination of the
+// process or once the node is stopped.
+type GenUDP struct {
+	GenServer
+}
+
+// GenUDPSocket represents the bound socket
+type GenUDPSocket struct {
+	conn    net.PacketConn
+	process *Process
+}
+
+type stateGenUDP struct {
+	p       *Process
+	options GenUDPOptions
+	socket  *GenUDPSocket
+	workers []*Process
+}
+
+type stateGenUDPWorker struct {
+	behaviour GenUDPBehaviour
+	socket    *GenUDPSocket
+}
+
+type genUDPWorker struct {
+	GenServer
+}
+
+type genUDPAddr struct{}
+
+type genUDPPacket struct {
+	addr   net.Addr
+	packet []byte
+}
+
+type genUDPClosed struct {
+	err error
+}
+
+// GenUDP methods
+
+// Addr returns the local address the socket is bound to
+func (gu *GenUDP) Addr(p *Process) (net.Addr, error) {
+	val, err := p.Call(p.Self(), genUDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	return val.(net.Addr), nil
+}
+
+// GenUDPSocket methods
+
+// Process returns the GenUDP process this socket belongs to
+func (s *GenUDPSocket) Process() *Process {
+	return s.process
+}
+
+// LocalAddr returns the local address the socket is bound to
+func (s *GenUDPSocket) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Send sends the datagram to the given address.
+// It's safe to call it from any goroutine.
+func (s *GenUDPSocket) Send(addr net.Addr, packet []byte) error {
+	_, err := s.conn.WriteTo(packet, addr)
+	return err
+}
+
+// GenServer callbacks
+
+func (gu *GenUDP) Init(p *Process, args ...interface{}) interface{} {
+	behaviour := p.object.(GenUDPBehaviour)
+	options := behaviour.InitUDP(p, args...)
+	if options.ReadBufferSize == 0 {
+		options.ReadBufferSize = defaultGenUDPReadBufferSize
+	}
+	if options.Workers == 0 {
+		options.Workers = defaultGenUDPWorkers
+	}
+	if options.ShutdownTimeout == 0 {
+		options.ShutdownTimeout = defaultGenUDPShutdownTimeout
+	}
+
+	conn, err := net.ListenPacket("udp", options.Listen)
+	if err != nil {
+		panic(err)
+	}
+
+	state := &stateGenUDP{
+		p:       p,
+		options: options,
+		socket: &GenUDPSocket{
+			conn:    conn,
+			process: p,
+		},
+	}
+
+	// workers are linked to this process
+	p.SetTrapExit(true)
+
+	opts := ProcessOptions{
+		// datagrams are handled one by one
+		DirectDispatch: true,
+		// slow worker makes the reader wait (back pressure)
+		MailboxOverflow: MailboxOverflowBlock,
+		parent:          p,
+	}
+	if p.groupLeader == nil {
+		opts.GroupLeader = p
+	} else {
+		opts.GroupLeader = p.groupLeader
+	}
+	for i := uint(0); i < options.Workers; i++ {
+		worker, err := p.Node.Spawn("", opts, &genUDPWorker{}, behaviour, state.socket)
+		if err != nil {
+			conn.Close()
+			for _, w := range state.workers {
+				w.Exit(p.Self(), "shutdown")
+			}
+			panic(err)
+		}
+		worker.parent = p
+		p.Link(worker.Self())
+		state.workers = append(state.workers, worker)
+	}
+
+	go func() {
+		// node is down or the process is killed
+		<-p.Context.Done()
+		conn.Close()
+	}()
+
+	for _, worker := range state.workers {
+		go gu.read(state, worker)
+	}
+
+	return state
+}
+
+func (gu *GenUDP) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	st := state.(*stateGenUDP)
+	switch message.(type) {
+	case genUDPAddr:
+		return "reply", st.socket.LocalAddr(), state
+	}
+	return "reply", ErrUnsupportedRequest, state
+}
+
+func (gu *GenUDP) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	fmt.Printf("GenUDP: unhandled message %#v\n", message)
+	return "noreply", state
+}
+
+func (gu *GenUDP) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	switch m := message.(type) {
+	case genUDPClosed:
+		// socket has been closed
+		return "stop", m.err.Error()
+
+	case etf.Tuple:
+		// {'EXIT', Pid, Reason}. the worker has been killed or it's
+		// an exit request from the parent or any other linked process
+		if len(m) == 3 && m.Element(1) == etf.Atom("EXIT") {
+			reason, ok := m.Element(3).(etf.Atom)
+			if !ok {
+				reason = etf.Atom(fmt.Sprintf("%v", m.Element(3)))
+			}
+			return "stop", string(reason)
+		}
+	}
+	fmt.Printf("GenUDP: unhandled message %#v\n", message)
+	return "noreply", state
+}
+
+func (gu *GenUDP) Terminate(reason string, state interface{}) {
+	st := state.(*stateGenUDP)
+	// stop reading first
+	st.socket.conn.Close()
+
+	// let the workers finish the datagrams they are handling
+	for _, worker := range st.workers {
+		worker.Exit(st.p.Self(), "shutdown")
+	}
+	for _, worker := range st.workers {
+		worker.WaitWithTimeout(st.options.ShutdownTimeout)
+	}
+}
+
+// default callbacks
+
+func (gu *GenUDP) HandlePacket(socket *GenUDPSocket, addr net.Addr, packet []byte) {
+	fmt.Printf("GenUDP HandlePacket: unhandled packet from %s\n", addr)
+}
+
+// private functions
+
+// read reads the datagrams and delivers them to the worker process
+// until the socket is closed.
+func (gu *GenUDP) read(st *stateGenUDP, worker *Process) {
+	buf := make([]byte, st.options.ReadBufferSize)
+	for {
+		n, addr, err := st.socket.conn.ReadFrom(buf)
+		if err != nil {
+			if st.p.IsAlive() {
+				st.p.Send(st.p.Self(), genUDPClosed{err: err})
+			}
+			return
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		worker.Send(worker.Self(), genUDPPacket{addr: addr, packet: packet})
+	}
+}
+
+func (w *genUDPWorker) Init(p *Process, args ...interface{}) interface{} {
+	return &stateGenUDPWorker{
+		behaviour: args[0].(GenUDPBehaviour),
+		socket:    args[1].(*GenUDPSocket),
+	}
+}
+
+func (w *genUDPWorker) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", ErrUnsupportedRequest, state
+}
+
+func (w *genUDPWorker) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+func (w *genUDPWorker) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	st := state.(*stateGenUDPWorker)
+	if m, ok := message.(genUDPPacket); ok {
+		w.handlePacket(st, m)
+	}
+	return "noreply", state
+}
+
+func (w *genUDPWorker) Terminate(reason string, state interface{}) {
+}
+
+func (w *genUDPWorker) handlePacket(st *stateGenUDPWorker, m genUDPPacket) {
+	defer func() {
+		if r := recover(); r != nil {
+			p := st.socket.process
+			fmt.Printf("Warning: GenUDP HandlePacket recovered (name: %s) %v %#v\n",
+				p.Name(), p.Self(), r)
+			p.Node.registerPanic()
+		}
+	}()
+	st.behaviour.HandlePacket(st.socket, m.addr, m.packet)
+}
@@ -0,0 +1,155 @@
+package ergo
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+type testGenUDP struct {
+	GenUDP
+	options GenUDPOptions
+	release chan bool
+}
+
+func (gu *testGenUDP) InitUDP(process *Process, args ...interface{}) GenUDPOptions {
+	return gu.options
+}
+
+func (gu *testGenUDP) HandlePacket(socket *GenUDPSocket, addr net.Addr, packet []byte) {
+	switch string(packet) {
+	case "panic":
+		panic("panic")
+	case "block":
+		<-gu.release
+	}
+	socket.Send(addr, append([]byte("echo:"), packet...))
+}
+
+func TestGenUDP(t *testing.T) {
+	fmt.Printf("\n=== Test GenUDP\n")
+	fmt.Printf("Starting node: nodeGenUDP@localhost: ")
+	node := CreateNode("nodeGenUDP@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	addresses := []string{"127.0.0.1:0"}
+	if c, err := net.ListenPacket("udp", "[::1]:0"); err == nil {
+		c.Close()
+		addresses = append(addresses, "[::1]:0")
+	}
+
+	for i, address := range addresses {
+		gu := &testGenUDP{
+			options: GenUDPOptions{
+				Listen:  address,
+				Workers: 2,
+			},
+		}
+		p, err := node.Spawn(fmt.Sprintf("udp%d", i), ProcessOptions{}, gu)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Printf("    local address (%s): ", address)
+		addr, err := gu.Addr(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.(*net.UDPAddr).Port == 0 {
+			t.Fatal("port is not defined", addr)
+		}
+		fmt.Println("OK")
+
+		fmt.Printf("    datagram is handled (%s): ", address)
+		conn, err := net.Dial("udp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 100)
+		for _, packet := range []string{"panic", "hello"} {
+			conn.Write([]byte(packet))
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "echo:hello" {
+			t.Fatalf("expected %q, got %q", "echo:hello", buf[:n])
+		}
+		fmt.Println("OK")
+
+		fmt.Printf("    socket is closed on termination (%s): ", address)
+		p.Exit(p.Self(), "normal")
+		if err := p.WaitWithTimeout(time.Second); err != nil {
+			t.Fatal(err)
+		}
+		c, err := net.ListenPacket("udp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		fmt.Println("OK")
+	}
+}
+
+func TestGenUDPShutdown(t *testing.T) {
+	fmt.Printf("\n=== Test GenUDP shutdown\n")
+	fmt.Printf("Starting node: nodeGenUDPShutdown@localhost: ")
+	exceeded := make(chan interface{}, 2)
+	opts := NodeOptions{
+		PanicIntensity: 1,
+		PanicPeriod:    10,
+		PanicBudgetExceeded: func(node *Node) {
+			exceeded <- node.FullName
+		},
+	}
+	node := CreateNode("nodeGenUDPShutdown@localhost", "cookies", opts)
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gu := &testGenUDP{
+		options: GenUDPOptions{
+			Listen:          "127.0.0.1:0",
+			ShutdownTimeout: 100 * time.Millisecond,
+		},
+		release: make(chan bool),
+	}
+	defer close(gu.release)
+	p, err := node.Spawn("udp", ProcessOptions{}, gu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := gu.Addr(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Printf("    recovered panics are taken into account by the panic budget: ")
+	conn.Write([]byte("panic"))
+	conn.Write([]byte("panic"))
+	waitForResultWithValue(t, exceeded, "nodeGenUDPShutdown@localhost")
+
+	fmt.Printf("    termination doesn't wait for the blocked worker longer than ShutdownTimeout: ")
+	conn.Write([]byte("block"))
+	time.Sleep(50 * time.Millisecond)
+	p.Exit(p.Self(), "normal")
+	if err := p.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+}
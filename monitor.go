@@ -3,6 +3,7 @@ package ergo
 // http://erlang.org/doc/reference_manual/processes.html
 
 import (
+	"github.com/halturin/ergo/dist"
 	"github.com/halturin/ergo/etf"
 	"github.com/halturin/ergo/lib"
 	"strings"
@@ -29,6 +30,8 @@ type monitor struct {
 	mutexLinks     sync.Mutex
 	nodes          map[string][]monitorItem
 	ref2node       map[string]string
+	nodesUp        map[string]uint32
+	nodesWatchers  map[etf.Pid]bool
 	mutexNodes     sync.Mutex
 
 	node *Node
@@ -43,6 +46,9 @@ func createMonitor(node *Node) *monitor {
 		ref2pid:  make(map[string]etf.Pid),
 		ref2node: make(map[string]string),
 
+		nodesUp:       make(map[string]uint32),
+		nodesWatchers: make(map[etf.Pid]bool),
+
 		node: node,
 	}
 
@@ -379,6 +385,37 @@ func (m *monitor) DemonitorNode(ref etf.Ref) {
 	delete(m.ref2node, key)
 }
 
+// MonitorNodes enables or disables the notifications about the status
+// change of the connections with the other nodes for the given process
+func (m *monitor) MonitorNodes(by etf.Pid, enable bool) {
+	lib.Log("[%s] MONITOR NODES : %v => %v", m.node.FullName, by, enable)
+
+	m.mutexNodes.Lock()
+	defer m.mutexNodes.Unlock()
+
+	if enable {
+		m.nodesWatchers[by] = true
+		return
+	}
+	delete(m.nodesWatchers, by)
+}
+
+func (m *monitor) NodeUp(name string, flags uint32) {
+	lib.Log("[%s] MONITOR NODE  up: %v", m.node.FullName, name)
+
+	m.mutexNodes.Lock()
+	m.nodesUp[name] = flags
+	watchers := make([]etf.Pid, 0, len(m.nodesWatchers))
+	for pid := range m.nodesWatchers {
+		watchers = append(watchers, pid)
+	}
+	m.mutexNodes.Unlock()
+
+	for _, pid := range watchers {
+		m.notifyNodeStatus(pid, "nodeup", name, flags)
+	}
+}
+
 func (m *monitor) NodeDown(name string) {
 	lib.Log("[%s] MONITOR NODE  down: %v", m.node.FullName, name)
 
@@ -390,8 +427,18 @@ func (m *monitor) NodeDown(name string) {
 			delete(m.nodes, name)
 		}
 	}
+	flags := m.nodesUp[name]
+	delete(m.nodesUp, name)
+	watchers := make([]etf.Pid, 0, len(m.nodesWatchers))
+	for pid := range m.nodesWatchers {
+		watchers = append(watchers, pid)
+	}
 	m.mutexNodes.Unlock()
 
+	for _, pid := range watchers {
+		m.notifyNodeStatus(pid, "nodedown", name, flags)
+	}
+
 	// notify process monitors
 	m.mutexProcesses.Lock()
 	for pid, ps := range m.processes {
@@ -508,6 +555,9 @@ func (m *monitor) ProcessTerminated(terminated etf.Pid, name, reason string) {
 	}
 	m.mutexLinks.Unlock()
 
+	m.mutexNodes.Lock()
+	delete(m.nodesWatchers, terminated)
+	m.mutexNodes.Unlock()
 }
 
 func (m *monitor) GetLinks(process etf.Pid) []etf.Pid {
@@ -555,6 +605,21 @@ func (m *monitor) notifyNodeDown(to etf.Pid, node string) {
 	m.node.registrar.route(etf.Pid{}, to, message)
 }
 
+// notifyNodeStatus sends {nodeup | nodedown, Node, Info}
+// where Info is [{node_type, visible | hidden}, {flags, Flags}]
+func (m *monitor) notifyNodeStatus(to etf.Pid, status etf.Atom, node string, flags uint32) {
+	nodeType := etf.Atom("visible")
+	if flags&uint32(dist.PUBLISHED) == 0 {
+		nodeType = etf.Atom("hidden")
+	}
+	info := etf.List{
+		etf.Tuple{etf.Atom("node_type"), nodeType},
+		etf.Tuple{etf.Atom("flags"), flags},
+	}
+	message := etf.Term(etf.Tuple{status, node, info})
+	m.node.registrar.route(etf.Pid{}, to, message)
+}
+
 func (m *monitor) notifyProcessTerminated(ref etf.Ref, to etf.Pid, terminated etf.Pid, reason string) {
 	// for remote {21, FromProc, ToPid, Ref, Reason}, where FromProc = monitored process
 	if to.Node != etf.Atom(m.node.FullName) {
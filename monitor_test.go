@@ -2,7 +2,9 @@ package ergo
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/halturin/ergo/etf"
 )
@@ -696,6 +698,74 @@ func TestLinkLocalRemote(t *testing.T) {
 	node1.Stop()
 }
 
+func TestMonitorNodes(t *testing.T) {
+	fmt.Printf("\n=== Test Monitor Nodes\n")
+	fmt.Printf("Starting nodes: nodeM1MonitorNodes@localhost, nodeM2MonitorNodes@localhost: ")
+	node1 := CreateNode("nodeM1MonitorNodes@localhost", "cookies", NodeOptions{})
+	node2 := CreateNode("nodeM2MonitorNodes@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	} else {
+		fmt.Println("OK")
+	}
+	defer node1.Stop()
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	checkNodeStatus := func(status etf.Atom, node string) {
+		select {
+		case v := <-gs1.v:
+			m, ok := v.(etf.Tuple)
+			if !ok || len(m) != 3 || m.Element(1) != status || m.Element(2) != node {
+				t.Fatal("unexpected message", v)
+			}
+			info := m.Element(3).(etf.List)
+			if !reflect.DeepEqual(info[0], etf.Tuple{etf.Atom("node_type"), etf.Atom("visible")}) {
+				t.Fatal("unexpected node info", info)
+			}
+			fmt.Println("OK")
+		case <-time.After(2 * time.Second):
+			t.Fatal("result timeout")
+		}
+	}
+
+	fmt.Printf("... gs1 receives nodeup: ")
+	node1gs1.MonitorNodes(true)
+	node1gs1.Send(node2gs2.Self(), etf.Atom("hi"))
+	checkNodeStatus(etf.Atom("nodeup"), node2.FullName)
+	fmt.Printf("... gs2 receives the message: ")
+	waitForResultWithValue(t, gs2.v, etf.Atom("hi"))
+
+	fmt.Printf("... gs1 receives nodedown: ")
+	node2.Stop()
+	checkNodeStatus(etf.Atom("nodedown"), node2.FullName)
+
+	node3 := CreateNode("nodeM3MonitorNodes@localhost", "cookies", NodeOptions{})
+	defer node3.Stop()
+	fmt.Printf("    wait for start of gs3 on %#v: ", node3.FullName)
+	node3gs3, _ := node3.Spawn("gs3", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node3gs3.Self())
+
+	fmt.Printf("... gs1 doesn't receive nodeup once it has unsubscribed: ")
+	node1gs1.MonitorNodes(false)
+	node1gs1.Send(node3gs3.Self(), etf.Atom("hi"))
+	waitForTimeout(t, gs1.v)
+	fmt.Println("OK")
+}
+
 // helpers
 func chechCleanProcessRef(node *Node, ref etf.Ref) error {
 	node.monitor.mutexProcesses.Lock()
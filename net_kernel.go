@@ -43,6 +43,11 @@ func (nks *netKernelSup) Init(args ...interface{}) SupervisorSpec {
 				Child:   &erlang{},
 				Restart: SupervisorChildRestartPermanent,
 			},
+			SupervisorChildSpec{
+				Name:    pgName,
+				Child:   &pg{},
+				Restart: SupervisorChildRestartPermanent,
+			},
 		},
 		Strategy: SupervisorStrategy{
 			Type:      SupervisorStrategyOneForOne,
@@ -13,7 +13,9 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"runtime"
+	"sync"
 	"sync/atomic"
 
 	"github.com/halturin/ergo/dist"
@@ -45,12 +47,17 @@ type Node struct {
 	StartedAt time.Time
 	uniqID    int64
 
-	tlscertServer tls.Certificate
-	tlscertClient tls.Certificate
+	tlsconfigServer *tls.Config
+	tlsconfigClient *tls.Config
 
 	FullName string
 
 	opts NodeOptions
+
+	panics      []int64
+	mutexPanics sync.Mutex
+
+	stopping int32
 }
 
 // NodeOptions struct with bootstrapping options for CreateNode
@@ -64,11 +71,42 @@ type NodeOptions struct {
 	RecvQueueLength        int
 	FragmentationUnit      int
 	DisableHeaderAtomCache bool
-	TLSmode                TLSmodeType
-	TLScrtServer           string
-	TLSkeyServer           string
-	TLScrtClient           string
-	TLSkeyClient           string
+	// StaticRoutes addresses ("host:port") of the nodes by their names. The node
+	// connects to these addresses directly without EPMD lookup.
+	StaticRoutes map[string]string
+	// Resolver returns the address ("host:port") of the node with given name.
+	// It's used if there is no static route to this node. EPMD lookup is
+	// the last resort if it returns an error. Optional.
+	Resolver func(name string) (string, error)
+
+	TLSmode      TLSmodeType
+	TLScrtServer string
+	TLSkeyServer string
+	// TLScrtClient and TLSkeyClient the certificate for the outgoing connections
+	// in TLSmodeStrict. The server one is used if they are not specified.
+	TLScrtClient string
+	TLSkeyClient string
+	// TLScaCert path to the CA certificate (PEM) for TLSmodeStrict. Enables
+	// mutual authentication: the certificate of the peer is verified against
+	// this CA for both incoming and outgoing connections.
+	TLScaCert string
+	// TLSconfig custom TLS configuration for the incoming and outgoing
+	// connections. TLSmode and the certificate options above are ignored
+	// if it's set. The cookie is checked on top of TLS anyway.
+	TLSconfig *tls.Config
+
+	// PanicIntensity and PanicPeriod define the panic budget of the node
+	// (the node-level analog of the supervisor restart intensity). If the
+	// processes of this node panic more than PanicIntensity times within
+	// PanicPeriod seconds the node is stopped. Every panic is taken into account
+	// including the ones the processes have recovered from (see
+	// ProcessOptions.RecoverFromPanic). Zero PanicIntensity (default)
+	// disables this check.
+	PanicIntensity uint16
+	PanicPeriod    uint16
+	// PanicBudgetExceeded is invoked (in a separate goroutine) instead of
+	// stopping the node if the panic budget is exceeded. Optional.
+	PanicBudgetExceeded func(node *Node)
 }
 
 // TLSmodeType should be one of TLSmodeDisabled (default), TLSmodeAuto or TLSmodeStrict
@@ -83,6 +121,8 @@ const (
 	defaultRecvQueueLength   int = 100
 	defaultFragmentationUnit     = 65000
 
+	defaultTLSHandshakeTimeout = 5 * time.Second
+
 	// TLSmodeDisabled no TLS encryption
 	TLSmodeDisabled TLSmodeType = ""
 	// TLSmodeAuto generate self-signed certificate
@@ -156,6 +196,11 @@ func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts
 		}
 		// start EPMD
 		node.epmd.Init(nodectx, name, listenPort, opts.EPMDPort, opts.Hidden, opts.DisableEPMDServer)
+		for routeName, routeAddr := range opts.StaticRoutes {
+			if err := node.AddStaticRouteAddr(routeName, routeAddr); err != nil {
+				panic(fmt.Sprintf("Can't add static route to %s: %s", routeName, err))
+			}
+		}
 
 		node.FullName = name
 	}
@@ -173,6 +218,9 @@ func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts
 
 // Spawn create new process
 func (n *Node) Spawn(name string, opts ProcessOptions, object interface{}, args ...interface{}) (*Process, error) {
+	if n.isStopping() {
+		return nil, ErrNodeStopping
+	}
 
 	process, err := n.registrar.RegisterProcessExt(name, object, opts)
 	if err != nil {
@@ -191,6 +239,7 @@ func (n *Node) Spawn(name string, opts ProcessOptions, object interface{}, args
 				n.registrar.UnregisterProcess(pid)
 				n.monitor.ProcessTerminated(pid, name, "panic")
 				process.Kill()
+				n.registerPanic()
 
 				process.ready <- fmt.Errorf("Can't start process: %s\n", r)
 				close(process.stopped)
@@ -255,6 +304,48 @@ func (n *Node) IsAlive() bool {
 	return n.context.Err() == nil
 }
 
+// StopWithTimeout stops the node gracefully. It refuses to spawn new processes
+// (so the supervisors don't restart their children), sends the exit request
+// with reason 'shutdown' to every process of this node and waits up to d for
+// them to terminate. The processes trapping exits receive it as the message
+// {'EXIT', From, shutdown}. The processes that are still alive once the timeout
+// is exceeded are killed. Returns the list of killed processes.
+func (n *Node) StopWithTimeout(d time.Duration) []etf.Pid {
+	killed := []etf.Pid{}
+	if !atomic.CompareAndSwapInt32(&n.stopping, 0, 1) {
+		// already stopping
+		n.Wait()
+		return killed
+	}
+
+	processes := n.GetProcessList()
+	for _, p := range processes {
+		// Exit could block if the process traps exits and its mailbox is full
+		go p.Exit(etf.Pid{}, "shutdown")
+	}
+
+	deadline := time.Now().Add(d)
+	for _, p := range processes {
+		timeout := time.Until(deadline)
+		if timeout > 0 && p.WaitWithTimeout(timeout) == nil {
+			continue
+		}
+		if !p.IsAlive() {
+			continue
+		}
+		lib.Log("[%s] process %v hasn't stopped in time. killing it", n.FullName, p.Self())
+		p.Kill()
+		killed = append(killed, p.Self())
+	}
+
+	n.Stop()
+	return killed
+}
+
+func (n *Node) isStopping() bool {
+	return atomic.LoadInt32(&n.stopping) == 1
+}
+
 // Wait waits until node stopped
 func (n *Node) Wait() {
 	<-n.context.Done()
@@ -290,6 +381,20 @@ func (n *Node) AddStaticRoute(name string, port uint16) error {
 	return n.epmd.AddStaticRoute(name, port)
 }
 
+// AddStaticRouteAddr adds static route record with the address ("host:port")
+// of the node into the EPMD client
+func (n *Node) AddStaticRouteAddr(name string, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("wrong port number %q", port)
+	}
+	return n.epmd.AddStaticRouteHost(name, host, uint16(p))
+}
+
 // RemoveStaticRoute removes static route record from the EPMD client
 func (n *Node) RemoveStaticRoute(name string) {
 	n.epmd.RemoveStaticRoute(name)
@@ -323,9 +428,16 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 		send: make([]chan []etf.Term, numHandlers),
 		n:    numHandlers,
 	}
+	p.context, p.cancel = context.WithCancel(n.context)
+	// the peer is available right after the registration, so the channels
+	// must be created before the writers are started
+	for i := 0; i < numHandlers; i++ {
+		p.send[i] = make(chan []etf.Term, opts.SendQueueLength)
+	}
 
 	if err := n.registrar.RegisterPeer(p); err != nil {
 		// duplicate link?
+		p.Close()
 		return err
 	}
 
@@ -366,11 +478,9 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 			link.Close()
 			n.registrar.UnregisterPeer(link.GetRemoteName())
 
-			// close handlers channel
+			// stop the writers and close handlers channel
+			p.Close()
 			for i := 0; i < numHandlers; i++ {
-				if p.send[i] != nil {
-					close(p.send[i])
-				}
 				if receivers.recv[i] != nil {
 					close(receivers.recv[i])
 				}
@@ -418,13 +528,15 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 	// run readers/writers for incoming/outgoing messages
 	for i := 0; i < numHandlers; i++ {
 		// run writer routines (encoder)
-		send := make(chan []etf.Term, opts.SendQueueLength)
-		p.mutex.Lock()
-		p.send[i] = send
-		p.mutex.Unlock()
-		go link.Writer(send, opts.FragmentationUnit)
+		go func(send chan []etf.Term) {
+			link.Writer(p.context, send, opts.FragmentationUnit)
+			// writer is stopped due to the error. close the link, so
+			// the reader stops the peer as well
+			link.Close()
+		}(p.send[i])
 	}
 
+	n.monitor.NodeUp(p.name, link.GetRemoteFlags())
 	return nil
 }
 
@@ -725,6 +837,74 @@ func (n *Node) RevokeRPC(module, function string) error {
 	return nil
 }
 
+// JoinGroup adds the local process to the process group with the given name.
+// The membership is propagated to the other nodes of the cluster and is
+// removed automatically once the process has terminated. Joining the group
+// the process is already a member of does nothing. The groups are served by
+// the system process registered as "pg", so this name is reserved.
+func (n *Node) JoinGroup(name string, pid etf.Pid) error {
+	pg, err := n.pg()
+	if err != nil {
+		return err
+	}
+	_, err = pg.Direct(pgJoin{group: name, pid: pid})
+	return err
+}
+
+// LeaveGroup removes the local process from the process group with the given name
+func (n *Node) LeaveGroup(name string, pid etf.Pid) error {
+	pg, err := n.pg()
+	if err != nil {
+		return err
+	}
+	_, err = pg.Direct(pgLeave{group: name, pid: pid})
+	return err
+}
+
+// GroupMembers returns the local and remote members of the process group with the given name.
+// The members of the remote nodes are learned asynchronously, so the list is eventually
+// consistent across the cluster.
+func (n *Node) GroupMembers(name string) []etf.Pid {
+	pg, err := n.pg()
+	if err != nil {
+		return nil
+	}
+	members, err := pg.Direct(pgMembers{group: name})
+	if err != nil {
+		return nil
+	}
+	return members.([]etf.Pid)
+}
+
+// PublishToGroup sends the message to every local and remote member of the process group
+func (n *Node) PublishToGroup(name string, message etf.Term) error {
+	pg, err := n.pg()
+	if err != nil {
+		return err
+	}
+	members, err := pg.Direct(pgMembers{group: name})
+	if err != nil {
+		return err
+	}
+	for _, pid := range members.([]etf.Pid) {
+		pg.Send(pid, message)
+	}
+	return nil
+}
+
+// pg returns the process serving the process groups
+func (n *Node) pg() (*Process, error) {
+	process := n.registrar.GetProcessByName(pgName)
+	if process == nil {
+		return nil, ErrGroupDisabled
+	}
+	if _, ok := process.object.(*pg); !ok {
+		// the name has been taken while pg was restarting
+		return nil, ErrGroupNameTaken
+	}
+	return process, nil
+}
+
 // GetProcessByName returns Process associated with given name
 func (n *Node) GetProcessByName(name string) *Process {
 	return n.registrar.GetProcessByName(name)
@@ -757,6 +937,41 @@ func (n *Node) MakeRef() (ref etf.Ref) {
 	return
 }
 
+// registerPanic takes into account the panic happened in one of the node's
+// processes. Stops the node (or invokes PanicBudgetExceeded if its defined)
+// once the panic budget is exceeded. It's called by the panicked process,
+// so the node is stopped asynchronously.
+func (n *Node) registerPanic() {
+	if n.opts.PanicIntensity == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	n.mutexPanics.Lock()
+	n.panics = append(n.panics, now)
+	if len(n.panics) <= int(n.opts.PanicIntensity) {
+		n.mutexPanics.Unlock()
+		return
+	}
+	period := now - n.panics[0]
+	n.panics = n.panics[1:]
+	if period > int64(n.opts.PanicPeriod) {
+		n.mutexPanics.Unlock()
+		return
+	}
+	// start counting from scratch to prevent triggering it on every further panic
+	n.panics = nil
+	n.mutexPanics.Unlock()
+
+	fmt.Printf("ERROR: Panic budget is exceeded (%d panics for %d seconds)\n",
+		n.opts.PanicIntensity, n.opts.PanicPeriod)
+	if n.opts.PanicBudgetExceeded != nil {
+		go n.opts.PanicBudgetExceeded(n)
+		return
+	}
+	go n.Stop()
+}
+
 func (n *Node) VersionERTS() string {
 	return fmt.Sprintf("%s-%s-%s", versionERTSprefix, version, runtime.Version())
 }
@@ -766,39 +981,27 @@ func (n *Node) VersionOTP() int {
 }
 
 func (n *Node) connect(to etf.Atom) error {
-	var port int
 	var err error
 	var c net.Conn
-	if port, err = n.epmd.ResolvePort(string(to)); port < 0 {
-		return fmt.Errorf("Can't resolve port for %s: %s", to, err)
+	addr, err := n.resolve(string(to))
+	if err != nil {
+		return err
 	}
-	ns := strings.Split(string(to), "@")
 
 	TLSenabled := false
 
-	switch n.opts.TLSmode {
-	case TLSmodeAuto:
+	if n.tlsconfigClient != nil {
 		tlsdialer := tls.Dialer{
-			Config: &tls.Config{
-				Certificates:       []tls.Certificate{n.tlscertClient},
-				InsecureSkipVerify: true,
-			},
+			Config: n.tlsconfigClient,
 		}
-		c, err = tlsdialer.DialContext(n.context, "tcp", net.JoinHostPort(ns[1], strconv.Itoa(port)))
-		TLSenabled = true
-
-	case TLSmodeStrict:
-		tlsdialer := tls.Dialer{
-			Config: &tls.Config{
-				Certificates: []tls.Certificate{n.tlscertClient},
-			},
+		c, err = tlsdialer.DialContext(n.context, "tcp", addr)
+		if err != nil {
+			err = fmt.Errorf("can't establish TLS connection with %s: %s", to, err)
 		}
-		c, err = tlsdialer.DialContext(n.context, "tcp", net.JoinHostPort(ns[1], strconv.Itoa(port)))
 		TLSenabled = true
-
-	default:
+	} else {
 		dialer := net.Dialer{}
-		c, err = dialer.DialContext(n.context, "tcp", net.JoinHostPort(ns[1], strconv.Itoa(port)))
+		c, err = dialer.DialContext(n.context, "tcp", addr)
 	}
 
 	if err != nil {
@@ -818,9 +1021,40 @@ func (n *Node) connect(to etf.Atom) error {
 	return nil
 }
 
+// resolve returns the address of the node with given name. Static routes
+// take precedence over the resolver, EPMD lookup is the last resort.
+func (n *Node) resolve(name string) (string, error) {
+	if addr, ok := n.epmd.ResolveStaticRoute(name); ok {
+		return addr, nil
+	}
+
+	var resolverErr error
+	if n.opts.Resolver != nil {
+		addr, err := n.opts.Resolver(name)
+		if err == nil {
+			return addr, nil
+		}
+		resolverErr = err
+	}
+
+	port, err := n.epmd.ResolvePort(name)
+	if err != nil {
+		if resolverErr != nil {
+			return "", fmt.Errorf("Can't resolve %s: no static route, resolver: %s, EPMD: %s", name, resolverErr, err)
+		}
+		return "", fmt.Errorf("Can't resolve %s: no static route, EPMD: %s", name, err)
+	}
+	ns := strings.Split(name, "@")
+	return net.JoinHostPort(ns[1], strconv.Itoa(port)), nil
+}
+
 func (n *Node) listen(name string, opts NodeOptions) uint16 {
 	var TLSenabled bool = true
 
+	if err := n.initTLS(opts); err != nil {
+		log.Fatalf("Can't initialize TLS: %s\n", err)
+	}
+
 	lc := net.ListenConfig{}
 	for p := opts.ListenRangeBegin; p <= opts.ListenRangeEnd; p++ {
 		l, err := lc.Listen(n.context, "tcp", net.JoinHostPort(name, strconv.Itoa(int(p))))
@@ -828,52 +1062,19 @@ func (n *Node) listen(name string, opts NodeOptions) uint16 {
 			continue
 		}
 
-		switch opts.TLSmode {
-		case TLSmodeAuto:
-			cert, err := generateSelfSignedCert()
-			if err != nil {
-				log.Fatalf("Can't generate certificate: %s\n", err)
-			}
-
-			n.tlscertServer = cert
-			n.tlscertClient = cert
-
-			TLSconfig := &tls.Config{
-				Certificates:       []tls.Certificate{cert},
-				InsecureSkipVerify: true,
-			}
-			l = tls.NewListener(l, TLSconfig)
-
-		case TLSmodeStrict:
-			certServer, err := tls.LoadX509KeyPair(opts.TLScrtServer, opts.TLSkeyServer)
-			if err != nil {
-				log.Fatalf("Can't load server certificate: %s\n", err)
-			}
-			certClient, err := tls.LoadX509KeyPair(opts.TLScrtServer, opts.TLSkeyServer)
-			if err != nil {
-				log.Fatalf("Can't load client certificate: %s\n", err)
-			}
-
-			n.tlscertServer = certServer
-			n.tlscertClient = certClient
-
-			TLSconfig := &tls.Config{
-				Certificates: []tls.Certificate{certServer},
-				ServerName:   "localhost",
-			}
-			l = tls.NewListener(l, TLSconfig)
-
-		default:
+		if n.tlsconfigServer != nil {
+			l = tls.NewListener(l, n.tlsconfigServer)
+		} else {
 			TLSenabled = false
 		}
 
 		go func() {
 			for {
 				c, err := l.Accept()
-				lib.Log("Accepted new connection from %s", c.RemoteAddr().String())
-
 				if n.IsAlive() == false {
-					c.Close()
+					if c != nil {
+						c.Close()
+					}
 					return
 				}
 
@@ -881,20 +1082,11 @@ func (n *Node) listen(name string, opts NodeOptions) uint16 {
 					lib.Log(err.Error())
 					continue
 				}
+				lib.Log("Accepted new connection from %s", c.RemoteAddr().String())
 
-				link, e := dist.HandshakeAccept(c, TLSenabled, n.FullName, n.Cookie, opts.Hidden)
-				if e != nil {
-					lib.Log("Can't handshake with %s: %s", c.RemoteAddr().String(), e)
-					c.Close()
-					continue
-				}
-
-				// start serving this link
-				if err := n.serve(link, opts); err != nil {
-					lib.Log("Can't serve connection link due to: %s", err)
-					c.Close()
-				}
-
+				// handshake within its own goroutine. the peer that is slow or silent
+				// mustn't block the other incoming connections
+				go n.accept(c, TLSenabled, opts)
 			}
 		}()
 
@@ -906,6 +1098,101 @@ func (n *Node) listen(name string, opts NodeOptions) uint16 {
 	return 0
 }
 
+// accept makes the handshake with the incoming connection and starts serving it
+func (n *Node) accept(c net.Conn, TLSenabled bool, opts NodeOptions) {
+	if tlsconn, ok := c.(*tls.Conn); ok {
+		// handshake explicitly to report the certificate mismatch clearly
+		tlsconn.SetDeadline(time.Now().Add(defaultTLSHandshakeTimeout))
+		err := tlsconn.Handshake()
+		tlsconn.SetDeadline(time.Time{})
+		if err != nil {
+			lib.Log("TLS handshake with %s failed: %s", c.RemoteAddr().String(), err)
+			c.Close()
+			return
+		}
+	}
+
+	link, e := dist.HandshakeAccept(c, TLSenabled, n.FullName, n.Cookie, opts.Hidden)
+	if e != nil {
+		lib.Log("Can't handshake with %s: %s", c.RemoteAddr().String(), e)
+		c.Close()
+		return
+	}
+
+	// start serving this link
+	if err := n.serve(link, opts); err != nil {
+		lib.Log("Can't serve connection link due to: %s", err)
+		c.Close()
+	}
+}
+
+// initTLS prepares TLS configuration for the incoming and outgoing connections
+func (n *Node) initTLS(opts NodeOptions) error {
+	if opts.TLSconfig != nil {
+		n.tlsconfigServer = opts.TLSconfig
+		n.tlsconfigClient = opts.TLSconfig
+		return nil
+	}
+
+	switch opts.TLSmode {
+	case TLSmodeAuto:
+		cert, err := generateSelfSignedCert()
+		if err != nil {
+			return fmt.Errorf("can't generate certificate: %s", err)
+		}
+
+		n.tlsconfigServer = &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		}
+		n.tlsconfigClient = &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		}
+
+	case TLSmodeStrict:
+		certServer, err := tls.LoadX509KeyPair(opts.TLScrtServer, opts.TLSkeyServer)
+		if err != nil {
+			return fmt.Errorf("can't load server certificate: %s", err)
+		}
+		// the server certificate is used for the outgoing connections
+		// as well unless the client one is specified
+		certClient := certServer
+		if opts.TLScrtClient != "" || opts.TLSkeyClient != "" {
+			certClient, err = tls.LoadX509KeyPair(opts.TLScrtClient, opts.TLSkeyClient)
+			if err != nil {
+				return fmt.Errorf("can't load client certificate: %s", err)
+			}
+		}
+
+		n.tlsconfigServer = &tls.Config{
+			Certificates: []tls.Certificate{certServer},
+			ServerName:   "localhost",
+		}
+		n.tlsconfigClient = &tls.Config{
+			Certificates: []tls.Certificate{certClient},
+		}
+
+		if opts.TLScaCert == "" {
+			return nil
+		}
+		ca, err := ioutil.ReadFile(opts.TLScaCert)
+		if err != nil {
+			return fmt.Errorf("can't load CA certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("can't parse CA certificate %s", opts.TLScaCert)
+		}
+		// mutual authentication
+		n.tlsconfigServer.ClientCAs = pool
+		n.tlsconfigServer.ClientAuth = tls.RequireAndVerifyClientCert
+		n.tlsconfigClient.RootCAs = pool
+	}
+
+	return nil
+}
+
 func generateSelfSignedCert() (tls.Certificate, error) {
 	var cert = tls.Certificate{}
 
@@ -1,16 +1,32 @@
 package ergo
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/halturin/ergo/dist"
 	"github.com/halturin/ergo/etf"
+	"github.com/halturin/ergo/lib"
 )
 
 type benchCase struct {
@@ -183,6 +199,68 @@ func TestNodeAtomCache(t *testing.T) {
 	}
 }
 
+// testHandshakeTailConn holds the challenge ack ('a' message) back and sends it
+// along with the next written data, so the peer gets them within a single read
+type testHandshakeTailConn struct {
+	net.Conn
+	ack []byte
+}
+
+func (c *testHandshakeTailConn) Write(b []byte) (int, error) {
+	if c.ack == nil && len(b) > 2 && b[2] == 'a' {
+		c.ack = append([]byte{}, b...)
+		return len(b), nil
+	}
+	if c.ack != nil {
+		data := append(c.ack, b...)
+		c.ack = []byte{}
+		if _, err := c.Conn.Write(data); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func TestNodeHandshakeTail(t *testing.T) {
+	fmt.Printf("\n=== Test Node handshake with the data following the challenge ack\n")
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	accepted := make(chan error, 1)
+	packet := []byte{0, 0, 0, 3, 1, 2, 3}
+	go func() {
+		conn := &testHandshakeTailConn{Conn: server}
+		_, err := dist.HandshakeAccept(conn, false, "nodeHandshakeTail1@localhost", "cookies", false)
+		if err == nil {
+			// the peer starts sending the messages right after the handshake
+			_, err = conn.Write(packet)
+		}
+		accepted <- err
+	}()
+
+	link, err := dist.Handshake(client, false, "nodeHandshakeTail2@localhost", "cookies", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    data is kept for the link reader: ")
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+	n, err := link.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b.B[:n], packet) {
+		t.Fatal("expected", packet, "got", b.B[:n])
+	}
+	fmt.Println("OK")
+}
+
 func TestNodeStaticRoute(t *testing.T) {
 	nodeName := "nodeT1StaticRoute@localhost"
 	nodeStaticPort := 9876
@@ -211,6 +289,401 @@ func TestNodeStaticRoute(t *testing.T) {
 	}
 }
 
+func TestNodeStaticRouteAddr(t *testing.T) {
+	fmt.Printf("\n=== Test Node static routes with address\n")
+	opts2 := NodeOptions{
+		ListenRangeBegin: 25102,
+		ListenRangeEnd:   25102,
+	}
+	node2 := CreateNode("nodeT2StaticRouteAddr@localhost", "secret", opts2)
+	defer node2.Stop()
+	p2, err := node2.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nodes below use their own EPMD, so node2 is not resolvable via EPMD
+	fmt.Printf("    static route bypasses EPMD: ")
+	opts1 := NodeOptions{
+		EPMDPort: 24998,
+		StaticRoutes: map[string]string{
+			node2.FullName: "127.0.0.1:25102",
+		},
+	}
+	node1 := CreateNode("nodeT1StaticRouteAddr@localhost", "secret", opts1)
+	defer node1.Stop()
+	p1, err := node1.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p1.CallWithTimeout(p2.Self(), etf.Atom("hi"), 2); err != nil || v != etf.Atom("hi") {
+		t.Fatal("unexpected result", v, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    resolver is used if there is no static route: ")
+	opts3 := NodeOptions{
+		EPMDPort: 24998,
+		Resolver: func(name string) (string, error) {
+			if name == node2.FullName {
+				return "127.0.0.1:25102", nil
+			}
+			return "", fmt.Errorf("unknown node")
+		},
+	}
+	node3 := CreateNode("nodeT3StaticRouteAddr@localhost", "secret", opts3)
+	defer node3.Stop()
+	p3, err := node3.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p3.CallWithTimeout(p2.Self(), etf.Atom("hi"), 2); err != nil || v != etf.Atom("hi") {
+		t.Fatal("unexpected result", v, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    unresolvable node name: ")
+	unknown := etf.Tuple{"gs", "nodeUnknownStaticRouteAddr@localhost"}
+	if _, err := p3.CallWithTimeout(unknown, etf.Atom("hi"), 2); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+}
+
+type testPanicGS struct {
+	GenServer
+}
+
+func (tgs *testPanicGS) Init(p *Process, args ...interface{}) interface{} {
+	return nil
+}
+func (tgs *testPanicGS) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testPanicGS) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	panic("test panic")
+}
+func (tgs *testPanicGS) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testPanicGS) Terminate(reason string, state interface{}) {
+}
+
+func TestNodePanicBudget(t *testing.T) {
+	fmt.Printf("\n=== Test Node panic budget\n")
+	exceeded := make(chan interface{}, 2)
+	opts := NodeOptions{
+		PanicIntensity: 2,
+		PanicPeriod:    10,
+		PanicBudgetExceeded: func(node *Node) {
+			exceeded <- node.FullName
+		},
+	}
+	node := CreateNode("nodePanicBudget@localhost", "cookies", opts)
+	defer node.Stop()
+
+	for i := 0; i < 2; i++ {
+		p, err := node.Spawn("", ProcessOptions{}, &testPanicGS{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Cast(p.Self(), "panic")
+		if err := p.WaitWithTimeout(time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fmt.Printf("    2 panics within the budget: ")
+	waitForTimeout(t, exceeded)
+	fmt.Println("OK")
+
+	fmt.Printf("    3rd panic exceeds the budget: ")
+	p, err := node.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Cast(p.Self(), "panic")
+	waitForResultWithValue(t, exceeded, node.FullName)
+
+	fmt.Printf("    recovered panics are taken into account: ")
+	var rp *Process
+	opts.PanicBudgetExceeded = func(node *Node) {
+		// must not be invoked within the panicked process
+		v, err := p.CallWithTimeout(rp.Self(), "ping", 1)
+		if err != nil {
+			exceeded <- err
+			return
+		}
+		exceeded <- v
+	}
+	node2 := CreateNode("nodePanicBudget2@localhost", "cookies", opts)
+	defer node2.Stop()
+	p, err = node2.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err = node2.Spawn("", ProcessOptions{RecoverFromPanic: true}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		rp.Cast(rp.Self(), "panic")
+	}
+	waitForResultWithValue(t, exceeded, "ping")
+
+	fmt.Printf("    node is stopped if there is no PanicBudgetExceeded: ")
+	opts.PanicBudgetExceeded = nil
+	node1 := CreateNode("nodePanicBudget1@localhost", "cookies", opts)
+	for i := 0; i < 3; i++ {
+		p, err := node1.Spawn("", ProcessOptions{}, &testPanicGS{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Cast(p.Self(), "panic")
+		p.WaitWithTimeout(time.Second)
+	}
+	if err := node1.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+}
+
+// testTLSCert creates the key pair signed by the given CA (self-signed if parent is nil)
+// and stores it in the dir as name.crt and name.key
+func testTLSCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			CommonName: name,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), crt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pk := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), pk, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestNodeTLS(t *testing.T) {
+	fmt.Printf("\n=== Test Node TLS\n")
+	dir, err := ioutil.TempDir("", "ergo-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := testTLSCert(t, dir, "ca", nil, nil)
+	testTLSCert(t, dir, "node", ca, caKey)
+	// certificate signed by another CA
+	ca1, ca1Key := testTLSCert(t, dir, "ca1", nil, nil)
+	testTLSCert(t, dir, "node1", ca1, ca1Key)
+
+	strict := func(crt, caCrt string) NodeOptions {
+		return NodeOptions{
+			TLSmode:      TLSmodeStrict,
+			TLScrtServer: filepath.Join(dir, crt+".crt"),
+			TLSkeyServer: filepath.Join(dir, crt+".key"),
+			TLScrtClient: filepath.Join(dir, crt+".crt"),
+			TLSkeyClient: filepath.Join(dir, crt+".key"),
+			TLScaCert:    filepath.Join(dir, caCrt+".crt"),
+		}
+	}
+
+	node1 := CreateNode("nodeTLS1@localhost", "cookies", strict("node", "ca"))
+	defer node1.Stop()
+	node2 := CreateNode("nodeTLS2@localhost", "cookies", strict("node", "ca"))
+	defer node2.Stop()
+
+	// the peer that doesn't send anything mustn't block the other incoming connections
+	port := node2.ResolvePort("nodeTLS2@localhost")
+	silent, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	p1, err := node1.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := node2.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    nodes with certificates of the same CA are connected: ")
+	if v, err := p1.CallWithTimeout(p2.Self(), etf.Atom("hi"), 2); err != nil || v != etf.Atom("hi") {
+		t.Fatal("unexpected result", v, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    server certificate is used if the client one is not specified: ")
+	opts := strict("node", "ca")
+	opts.TLScrtClient = ""
+	opts.TLSkeyClient = ""
+	node5 := CreateNode("nodeTLS5@localhost", "cookies", opts)
+	defer node5.Stop()
+	p5, err := node5.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p5.CallWithTimeout(p2.Self(), etf.Atom("hi"), 2); err != nil || v != etf.Atom("hi") {
+		t.Fatal("unexpected result", v, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    node with certificate of another CA is rejected: ")
+	node3 := CreateNode("nodeTLS3@localhost", "cookies", strict("node1", "ca1"))
+	defer node3.Stop()
+	p3, err := node3.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p3.CallWithTimeout(p1.Self(), etf.Atom("hi"), 1); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := p1.CallWithTimeout(p3.Self(), etf.Atom("hi"), 1); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    custom TLS config: ")
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	opts = NodeOptions{
+		TLSconfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	}
+	node4 := CreateNode("nodeTLS4@localhost", "cookies", opts)
+	defer node4.Stop()
+	p4, err := node4.Spawn("", ProcessOptions{}, &testPanicGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p4.CallWithTimeout(p2.Self(), etf.Atom("hi"), 2); err != nil || v != etf.Atom("hi") {
+		t.Fatal("unexpected result", v, err)
+	}
+	if v, err := p1.CallWithTimeout(p4.Self(), etf.Atom("hi"), 2); err != nil || v != etf.Atom("hi") {
+		t.Fatal("unexpected result", v, err)
+	}
+	fmt.Println("OK")
+}
+
+type testStopTrapGS struct {
+	GenServer
+}
+
+func (tgs *testStopTrapGS) Init(p *Process, args ...interface{}) interface{} {
+	// ignores the exit request
+	p.SetTrapExit(true)
+	return nil
+}
+func (tgs *testStopTrapGS) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testStopTrapGS) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testStopTrapGS) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testStopTrapGS) Terminate(reason string, state interface{}) {
+}
+
+func TestNodeStopWithTimeout(t *testing.T) {
+	fmt.Printf("\n=== Test Node StopWithTimeout\n")
+	node := CreateNode("nodeStopWithTimeout@localhost", "cookies", NodeOptions{})
+
+	fmt.Printf("    starting supervisor: ")
+	sv := &testSupervisorOneForOne{
+		ch: make(chan interface{}, 10),
+	}
+	if _, err := node.Spawn("sv", ProcessOptions{}, sv, SupervisorChildRestartPermanent, sv.ch); err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(sv.ch, 3, make([]etf.Pid, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	trap, err := node.Spawn("", ProcessOptions{}, &testStopTrapGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    children are stopped and not restarted: ")
+	killedCh := make(chan []etf.Pid, 1)
+	go func() {
+		killedCh <- node.StopWithTimeout(300 * time.Millisecond)
+	}()
+	children1, err := waitNeventsSupervisorChildren(sv.ch, 3, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{"empty", "empty", "empty"}
+	if !checkExpectedChildrenStatus(children, children1, statuses) {
+		t.Fatal("unexpected children status", children, children1)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    process ignoring the exit request is killed: ")
+	killed := <-killedCh
+	if !reflect.DeepEqual(killed, []etf.Pid{trap.Self()}) {
+		t.Fatal("unexpected killed processes", killed)
+	}
+	if node.IsAlive() {
+		t.Fatal("node is still alive")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    node refuses to spawn new process: ")
+	if _, err := node.Spawn("", ProcessOptions{}, &testStopTrapGS{}); err != ErrNodeStopping {
+		t.Fatal("expected ErrNodeStopping, got", err)
+	}
+	fmt.Println("OK")
+}
+
 type benchGS struct {
 	GenServer
 }
@@ -395,3 +868,29 @@ func benchCases() []benchCase {
 		benchCase{"binary 1MB", make([]byte, 1024*1024)},
 	}
 }
+
+func TestNodePeerClose(t *testing.T) {
+	fmt.Printf("\n=== Test Node peer close\n")
+	fmt.Printf("    closing the peer unblocks the sender: ")
+	p := &peer{
+		name: "nodePeerClose@localhost",
+		send: []chan []etf.Term{make(chan []etf.Term)},
+		n:    1,
+	}
+	p.context, p.cancel = context.WithCancel(context.Background())
+
+	// nobody reads the send channel (writer has been stopped)
+	sent := make(chan interface{}, 1)
+	go func() {
+		sent <- p.Send([]etf.Term{etf.Atom("hi")})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	p.Close()
+	waitForResultWithValue(t, sent, false)
+
+	fmt.Printf("    sending to the closed peer: ")
+	if p.Send([]etf.Term{etf.Atom("hi")}) {
+		t.Fatal("message is sent to the closed peer")
+	}
+	fmt.Println("OK")
+}
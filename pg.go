@@ -0,0 +1,425 @@
+package ergo
+
+// https://github.com/erlang/otp/blob/master/lib/kernel/src/pg.erl
+
+import (
+	"github.com/halturin/ergo/etf"
+	"github.com/halturin/ergo/lib"
+)
+
+const (
+	// pgName the registered name of the pg process. It must be the same across
+	// the cluster (Erlang's pg uses it for the default scope), so this name is
+	// reserved and can't be taken by the user's process.
+	pgName = "pg"
+)
+
+// pg keeps the membership of the process groups. Every node runs its own pg process
+// which is aware of the local members only. The remote members are learned from
+// the pg processes (peers) of the other nodes, so the state of the groups is eventually
+// consistent across the cluster. The protocol is compatible with Erlang's pg module.
+type pg struct {
+	GenServer
+	process *Process
+
+	// group => members (local and remote)
+	groups map[string]map[etf.Pid]bool
+	// local member => its monitor and the groups it has joined
+	local map[etf.Pid]*pgLocalMember
+	// pg process of the remote node => its monitor and the groups of its members
+	peers map[etf.Pid]*pgPeer
+}
+
+type pgLocalMember struct {
+	ref    etf.Ref
+	groups map[string]bool
+}
+
+type pgPeer struct {
+	ref    etf.Ref
+	groups map[string][]etf.Pid
+}
+
+// requests of the Node's API (see JoinGroup, LeaveGroup, GroupMembers)
+type pgJoin struct {
+	group string
+	pid   etf.Pid
+}
+
+type pgLeave struct {
+	group string
+	pid   etf.Pid
+}
+
+type pgMembers struct {
+	group string
+}
+
+// Init initializes process state using arbitrary arguments
+// Init(...) -> state
+func (g *pg) Init(p *Process, args ...interface{}) (state interface{}) {
+	lib.Log("PG: Init: %#v", args)
+	g.process = p
+	g.groups = make(map[string]map[etf.Pid]bool)
+	g.local = make(map[etf.Pid]*pgLocalMember)
+	g.peers = make(map[etf.Pid]*pgPeer)
+
+	p.MonitorNodes(true)
+	for _, node := range p.Node.GetPeerList() {
+		g.discover(node)
+	}
+	return nil
+}
+
+// HandleCast -> ("noreply", state) - noreply
+//		         ("stop", reason) - stop with reason
+func (g *pg) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	lib.Log("PG: HandleCast: %#v", message)
+	return "noreply", state
+}
+
+// HandleCall serves incoming messages sending via gen_server:call
+// HandleCall -> ("reply", message, state) - reply
+//				 ("noreply", _, state) - noreply
+//		         ("stop", reason, _) - normal stop
+func (g *pg) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	lib.Log("PG: HandleCall: %#v, From: %#v", message, from)
+	return "reply", etf.Atom("ok"), state
+}
+
+// HandleInfo serves all another incoming messages (Pid ! message)
+// HandleInfo -> ("noreply", state) - noreply
+//		         ("stop", reason) - normal stop
+func (g *pg) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	lib.Log("PG: HandleInfo: %#v", message)
+	m, ok := message.(etf.Tuple)
+	if !ok || len(m) < 2 {
+		return "noreply", state
+	}
+
+	switch m.Element(1) {
+	case etf.Atom("nodeup"):
+		// {nodeup, Node, Info}
+		if node, ok := etf.StringTerm(m.Element(2)); ok {
+			g.discover(node)
+		}
+
+	case etf.Atom("DOWN"):
+		// {'DOWN', Ref, process, Pid, Reason}
+		if len(m) != 5 {
+			break
+		}
+		if pid, ok := m.Element(4).(etf.Pid); ok {
+			g.handleDown(pid)
+		}
+
+	case etf.Atom("discover"):
+		// {discover, Peer}
+		if peer, ok := m.Element(2).(etf.Pid); ok {
+			g.handleDiscover(peer)
+		}
+
+	case etf.Atom("join"):
+		// {join, Peer, Group, PidOrPids}
+		if len(m) != 4 {
+			break
+		}
+		peer, ok := m.Element(2).(etf.Pid)
+		if !ok {
+			break
+		}
+		group, ok := etf.StringTerm(m.Element(3))
+		if !ok {
+			break
+		}
+		g.handleRemoteJoin(peer, group, pgPids(m.Element(4)))
+
+	case etf.Atom("leave"):
+		// {leave, Peer, PidOrPids, Groups}
+		if len(m) != 4 {
+			break
+		}
+		peer, ok := m.Element(2).(etf.Pid)
+		if !ok {
+			break
+		}
+		groups, ok := m.Element(4).(etf.List)
+		if !ok {
+			break
+		}
+		g.handleRemoteLeave(peer, pgPids(m.Element(3)), groups)
+
+	case etf.Atom("sync"):
+		// {sync, Peer, [{Group, Pids}]}
+		if len(m) != 3 {
+			break
+		}
+		peer, ok := m.Element(2).(etf.Pid)
+		if !ok {
+			break
+		}
+		groups, ok := m.Element(3).(etf.List)
+		if !ok {
+			break
+		}
+		g.handleSync(peer, groups)
+	}
+
+	return "noreply", state
+}
+
+// HandleDirect serves the requests of the Node's API
+func (g *pg) HandleDirect(message interface{}) (interface{}, error) {
+	switch m := message.(type) {
+	case pgJoin:
+		return nil, g.join(m.group, m.pid)
+	case pgLeave:
+		return nil, g.leave(m.group, m.pid)
+	case pgMembers:
+		members := make([]etf.Pid, 0, len(g.groups[m.group]))
+		for pid := range g.groups[m.group] {
+			members = append(members, pid)
+		}
+		return members, nil
+	}
+	return nil, ErrUnsupportedRequest
+}
+
+// Terminate called when process died
+func (g *pg) Terminate(reason string, state interface{}) {
+	lib.Log("PG: Terminate: %#v", reason)
+}
+
+func (g *pg) join(group string, pid etf.Pid) error {
+	if string(pid.Node) != g.process.Node.FullName {
+		return ErrGroupRemoteProcess
+	}
+
+	member, ok := g.local[pid]
+	if !ok {
+		member = &pgLocalMember{
+			ref:    g.process.MonitorProcess(pid),
+			groups: make(map[string]bool),
+		}
+		g.local[pid] = member
+	}
+	if member.groups[group] {
+		// already joined
+		return nil
+	}
+	member.groups[group] = true
+	g.addMembers(group, []etf.Pid{pid})
+
+	message := etf.Tuple{etf.Atom("join"), g.process.Self(), group, etf.List{pid}}
+	g.broadcast(message)
+	return nil
+}
+
+func (g *pg) leave(group string, pid etf.Pid) error {
+	member, ok := g.local[pid]
+	if !ok || !member.groups[group] {
+		return ErrGroupNotMember
+	}
+	delete(member.groups, group)
+	if len(member.groups) == 0 {
+		g.process.DemonitorProcess(member.ref)
+		delete(g.local, pid)
+	}
+	g.removeMembers(group, []etf.Pid{pid})
+
+	message := etf.Tuple{etf.Atom("leave"), g.process.Self(), etf.List{pid}, etf.List{group}}
+	g.broadcast(message)
+	return nil
+}
+
+func (g *pg) handleDown(pid etf.Pid) {
+	if member, ok := g.local[pid]; ok {
+		// local member has terminated
+		delete(g.local, pid)
+		groups := etf.List{}
+		for group := range member.groups {
+			g.removeMembers(group, []etf.Pid{pid})
+			groups = append(groups, group)
+		}
+		message := etf.Tuple{etf.Atom("leave"), g.process.Self(), etf.List{pid}, groups}
+		g.broadcast(message)
+		return
+	}
+
+	if peer, ok := g.peers[pid]; ok {
+		// remote pg process has terminated or the node went down
+		delete(g.peers, pid)
+		for group, pids := range peer.groups {
+			g.removeMembers(group, pids)
+		}
+	}
+}
+
+func (g *pg) handleDiscover(peer etf.Pid) {
+	if _, ok := g.peers[peer]; !ok {
+		g.peers[peer] = &pgPeer{
+			ref:    g.process.MonitorProcess(peer),
+			groups: make(map[string][]etf.Pid),
+		}
+		g.process.Send(peer, etf.Tuple{etf.Atom("discover"), g.process.Self()})
+	}
+
+	// send the local members
+	groups := make(map[string]etf.List)
+	for pid, member := range g.local {
+		for group := range member.groups {
+			groups[group] = append(groups[group], pid)
+		}
+	}
+	sync := etf.List{}
+	for group, pids := range groups {
+		sync = append(sync, etf.Tuple{group, pids})
+	}
+	g.process.Send(peer, etf.Tuple{etf.Atom("sync"), g.process.Self(), sync})
+}
+
+func (g *pg) handleRemoteJoin(peer etf.Pid, group string, pids []etf.Pid) {
+	p, ok := g.peers[peer]
+	if !ok {
+		// unknown peer. it will send the sync message once it's discovered
+		return
+	}
+	for i := range pids {
+		if pgContains(p.groups[group], pids[i]) {
+			// repeated join
+			continue
+		}
+		p.groups[group] = append(p.groups[group], pids[i])
+	}
+	g.addMembers(group, pids)
+}
+
+func (g *pg) handleRemoteLeave(peer etf.Pid, pids []etf.Pid, groups etf.List) {
+	p, ok := g.peers[peer]
+	if !ok {
+		return
+	}
+	for i := range groups {
+		group, ok := etf.StringTerm(groups[i])
+		if !ok {
+			continue
+		}
+		p.groups[group] = pgExclude(p.groups[group], pids)
+		if len(p.groups[group]) == 0 {
+			delete(p.groups, group)
+		}
+		g.removeMembers(group, pids)
+	}
+}
+
+func (g *pg) handleSync(peer etf.Pid, groups etf.List) {
+	p, ok := g.peers[peer]
+	if !ok {
+		// sync message could come ahead of the discover one
+		p = &pgPeer{
+			ref: g.process.MonitorProcess(peer),
+		}
+		g.peers[peer] = p
+	}
+
+	// replace the members of this peer
+	for group, pids := range p.groups {
+		g.removeMembers(group, pids)
+	}
+	p.groups = make(map[string][]etf.Pid)
+	for i := range groups {
+		t, ok := groups[i].(etf.Tuple)
+		if !ok || len(t) != 2 {
+			continue
+		}
+		group, ok := etf.StringTerm(t.Element(1))
+		if !ok {
+			continue
+		}
+		pids := pgPids(t.Element(2))
+		p.groups[group] = pids
+		g.addMembers(group, pids)
+	}
+}
+
+func (g *pg) discover(node string) {
+	if node == g.process.Node.FullName {
+		return
+	}
+	to := etf.Tuple{pgName, node}
+	g.process.Send(to, etf.Tuple{etf.Atom("discover"), g.process.Self()})
+}
+
+func (g *pg) broadcast(message etf.Term) {
+	for peer := range g.peers {
+		g.process.Send(peer, message)
+	}
+}
+
+func (g *pg) addMembers(group string, pids []etf.Pid) {
+	members, ok := g.groups[group]
+	if !ok {
+		members = make(map[etf.Pid]bool)
+		g.groups[group] = members
+	}
+	for i := range pids {
+		members[pids[i]] = true
+	}
+}
+
+func (g *pg) removeMembers(group string, pids []etf.Pid) {
+	members, ok := g.groups[group]
+	if !ok {
+		return
+	}
+	for i := range pids {
+		delete(members, pids[i])
+	}
+	if len(members) == 0 {
+		delete(g.groups, group)
+	}
+}
+
+// pgPids returns the list of pids out of the Pid or the list of Pids
+func pgPids(term etf.Term) []etf.Pid {
+	switch t := term.(type) {
+	case etf.Pid:
+		return []etf.Pid{t}
+	case etf.List:
+		pids := make([]etf.Pid, 0, len(t))
+		for i := range t {
+			if pid, ok := t[i].(etf.Pid); ok {
+				pids = append(pids, pid)
+			}
+		}
+		return pids
+	}
+	return nil
+}
+
+func pgContains(pids []etf.Pid, pid etf.Pid) bool {
+	for i := range pids {
+		if pids[i] == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// pgExclude returns the pids except the excluded ones
+func pgExclude(pids []etf.Pid, excluded []etf.Pid) []etf.Pid {
+	result := pids[:0]
+	for i := range pids {
+		keep := true
+		for k := range excluded {
+			if pids[i] == excluded[k] {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, pids[i])
+		}
+	}
+	return result
+}
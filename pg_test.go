@@ -0,0 +1,159 @@
+package ergo
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+type testPgGenServer struct {
+	GenServer
+	v chan interface{}
+}
+
+func (tgs *testPgGenServer) Init(p *Process, args ...interface{}) interface{} {
+	return nil
+}
+func (tgs *testPgGenServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testPgGenServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "stop", "normal"
+}
+func (tgs *testPgGenServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testPgGenServer) Terminate(reason string, state interface{}) {
+}
+
+// waitGroupMembers waits until the group gets the expected members
+func waitGroupMembers(t *testing.T, node *Node, name string, expected ...etf.Pid) {
+	sortPids := func(pids []etf.Pid) {
+		sort.Slice(pids, func(i, j int) bool {
+			if pids[i].Node != pids[j].Node {
+				return pids[i].Node < pids[j].Node
+			}
+			return pids[i].ID < pids[j].ID
+		})
+	}
+	sortPids(expected)
+
+	var members []etf.Pid
+	for i := 0; i < 40; i++ {
+		members = node.GroupMembers(name)
+		sortPids(members)
+		if fmt.Sprint(members) == fmt.Sprint(expected) {
+			fmt.Println("OK")
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("expected members", expected, "got", members)
+}
+
+func TestProcessGroups(t *testing.T) {
+	fmt.Printf("\n=== Test Process Groups\n")
+	fmt.Printf("Starting nodes: nodePg1@localhost, nodePg2@localhost: ")
+	node1 := CreateNode("nodePg1@localhost", "cookies", NodeOptions{})
+	node2 := CreateNode("nodePg2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	fmt.Println("OK")
+	defer node1.Stop()
+
+	gs1 := &testPgGenServer{v: make(chan interface{}, 2)}
+	gs2 := &testPgGenServer{v: make(chan interface{}, 2)}
+	gs3 := &testPgGenServer{v: make(chan interface{}, 2)}
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1)
+	node1gs2, _ := node1.Spawn("gs2", ProcessOptions{}, gs2)
+	node2gs3, _ := node2.Spawn("gs3", ProcessOptions{}, gs3)
+
+	fmt.Printf("    join local processes: ")
+	if err := node1.JoinGroup("test", node1gs1.Self()); err != nil {
+		t.Fatal(err)
+	}
+	if err := node1.JoinGroup("test", node1gs2.Self()); err != nil {
+		t.Fatal(err)
+	}
+	// joining twice does nothing
+	if err := node1.JoinGroup("test", node1gs2.Self()); err != nil {
+		t.Fatal(err)
+	}
+	waitGroupMembers(t, node1, "test", node1gs1.Self(), node1gs2.Self())
+
+	fmt.Printf("    remote process can't join: ")
+	if err := node1.JoinGroup("test", node2gs3.Self()); err != ErrGroupRemoteProcess {
+		t.Fatal("expected ErrGroupRemoteProcess, got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    members are propagated once the nodes are connected: ")
+	if err := node2.JoinGroup("test", node2gs3.Self()); err != nil {
+		t.Fatal(err)
+	}
+	node1gs1.Send(node2gs3.Self(), etf.Atom("hi"))
+	if m := <-gs3.v; m != etf.Atom("hi") {
+		t.Fatal("unexpected message", m)
+	}
+	waitGroupMembers(t, node2, "test", node1gs1.Self(), node1gs2.Self(), node2gs3.Self())
+	fmt.Printf("    members of the remote node: ")
+	waitGroupMembers(t, node1, "test", node1gs1.Self(), node1gs2.Self(), node2gs3.Self())
+
+	fmt.Printf("    repeated join from the peer is removed by a single leave: ")
+	pg2 := node2.GetProcessByName("pg")
+	join := etf.Tuple{etf.Atom("join"), pg2.Self(), "repeated", etf.List{node2gs3.Self()}}
+	pg2.Send(etf.Tuple{"pg", "nodePg1@localhost"}, join)
+	pg2.Send(etf.Tuple{"pg", "nodePg1@localhost"}, join)
+	waitGroupMembers(t, node1, "repeated", node2gs3.Self())
+	// the messages could be delivered out of order (they are sent
+	// over the different channels of the connection)
+	time.Sleep(100 * time.Millisecond)
+	leave := etf.Tuple{etf.Atom("leave"), pg2.Self(), etf.List{node2gs3.Self()}, etf.List{"repeated"}}
+	pg2.Send(etf.Tuple{"pg", "nodePg1@localhost"}, leave)
+	fmt.Printf("    ... leave: ")
+	waitGroupMembers(t, node1, "repeated")
+
+	fmt.Printf("    name 'pg' is reserved: ")
+	if _, err := node1.Spawn("pg", ProcessOptions{}, &testPgGenServer{}); err != ErrNameIsTaken {
+		t.Fatal("expected ErrNameIsTaken, got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    publish to the local and remote members: ")
+	if err := node2.PublishToGroup("test", etf.Atom("event")); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []chan interface{}{gs1.v, gs2.v, gs3.v} {
+		select {
+		case m := <-v:
+			if m != etf.Atom("event") {
+				t.Fatal("unexpected message", m)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    leave the group: ")
+	if err := node1.LeaveGroup("test", node1gs1.Self()); err != nil {
+		t.Fatal(err)
+	}
+	if err := node1.LeaveGroup("test", node1gs1.Self()); err != ErrGroupNotMember {
+		t.Fatal("expected ErrGroupNotMember, got", err)
+	}
+	waitGroupMembers(t, node2, "test", node1gs2.Self(), node2gs3.Self())
+
+	fmt.Printf("    terminated member is removed: ")
+	node1gs2.Cast(node1gs2.Self(), "stop")
+	waitGroupMembers(t, node2, "test", node2gs3.Self())
+
+	fmt.Printf("    members of the node that went down are removed: ")
+	node2.Stop()
+	waitGroupMembers(t, node1, "test")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/halturin/ergo/etf"
@@ -11,26 +12,53 @@ import (
 )
 
 type ProcessType = string
+type MailboxOverflow = string
 
 const (
 	DefaultProcessMailboxSize = 100
+
+	// Mailbox overflow policies (what happens with a message sent to the process
+	// whose mailbox is full):
+
+	// MailboxOverflowDropNewest the message is dropped (with a warning unless
+	// MailboxOverflowNotify is enabled). This is the default policy.
+	MailboxOverflowDropNewest = MailboxOverflow("drop_newest")
+
+	// MailboxOverflowBlock the sender is blocked until the mailbox has room
+	// for the message (or the process is terminated). No message is dropped.
+	MailboxOverflowBlock = MailboxOverflow("block")
+
+	// MailboxOverflowDropOldest the oldest message in the mailbox is dropped
+	// to make room for the new one.
+	MailboxOverflowDropOldest = MailboxOverflow("drop_oldest")
+
+	// MailboxOverflowFail the message is dropped and the sender gets ErrMailboxFull.
+	MailboxOverflowFail = MailboxOverflow("fail")
 )
 
+// MessageMailboxOverflow is delivered to the GenServer (as an info message)
+// if MailboxOverflowNotify is enabled and some messages have been dropped
+// due to the mailbox overflow.
+type MessageMailboxOverflow struct {
+	Dropped int
+}
+
 type Process struct {
 	sync.RWMutex
 
-	mailBox      chan etf.Tuple
-	ready        chan error
-	gracefulExit chan gracefulExitRequest
-	direct       chan directMessage
-	stopped      chan bool
-	self         etf.Pid
-	groupLeader  *Process
-	Context      context.Context
-	Kill         context.CancelFunc
-	Exit         ProcessExitFunc
-	name         string
-	Node         *Node
+	mailBox         chan etf.Tuple
+	mailBoxPriority chan etf.Tuple
+	ready           chan error
+	gracefulExit    chan gracefulExitRequest
+	direct          chan directMessage
+	stopped         chan bool
+	self            etf.Pid
+	groupLeader     *Process
+	Context         context.Context
+	Kill            context.CancelFunc
+	Exit            ProcessExitFunc
+	name            string
+	Node            *Node
 
 	object interface{}
 	state  interface{}
@@ -40,9 +68,26 @@ type Process struct {
 
 	parent          *Process
 	reductions      uint64 // we use this term to count total number of processed messages from mailBox
-	currentFunction string
+	currentFunction atomic.Value // string
+
+	trapExit int32
+
+	prioritySenders map[etf.Pid]bool
+	continuations   []etf.Term
+
+	// directDispatch makes the GenServer loop invoke callbacks inline (see ProcessOptions)
+	directDispatch bool
+	// inCallback is set by the GenServer loop while it runs a callback inline
+	inCallback int32
+	// pending keeps the messages have been taken from the mailbox during
+	// a call made within an inline callback. Only the loop goroutine touches it.
+	pending []etf.Tuple
 
-	trapExit bool
+	overflow       MailboxOverflow
+	overflowNotify bool
+	dropped        int64
+
+	recoverFromPanic bool
 }
 
 type directMessage struct {
@@ -73,10 +118,43 @@ type ProcessInfo struct {
 	Reductions      uint64
 }
 
+// ProcessStats struct with runtime statistics of the process (see Process.Stats)
+type ProcessStats struct {
+	Reductions      uint64
+	MessageQueueLen int
+	CurrentFunction string
+	TrapExit        bool
+}
+
 type ProcessOptions struct {
 	MailboxSize uint16
 	GroupLeader *Process
-	parent      *Process
+	// DirectDispatch makes GenServer run its callbacks inline in the loop goroutine
+	// instead of spawning a goroutine per message, so the messages are handled
+	// strictly in the order they have been received (like Erlang does).
+	// Making a Call within the callback is still possible - the reply is taken
+	// from the mailbox directly and the other messages are kept in order. But
+	// calling itself is not (it would wait for itself forever), so such Call
+	// returns ErrSelfCall immediately.
+	DirectDispatch bool
+	// MailboxOverflow defines the policy for the messages sent to the process
+	// whose mailbox is full. Default is MailboxOverflowDropNewest.
+	// It applies to Send and Cast the same way: they block the caller with
+	// MailboxOverflowBlock, and SendWithError/CastWithError return ErrMailboxFull
+	// with MailboxOverflowFail. SendAfter and CastAfter deliver the message
+	// from a timer goroutine so the error is just discarded and
+	// MailboxOverflowBlock blocks the timer, not the caller. Keep in mind
+	// the messages from the remote nodes are delivered by the connection
+	// goroutine so MailboxOverflowBlock stalls the whole connection.
+	MailboxOverflow MailboxOverflow
+	// MailboxOverflowNotify makes GenServer receive MessageMailboxOverflow with
+	// the number of dropped messages (for the drop policies only).
+	MailboxOverflowNotify bool
+	// RecoverFromPanic keeps GenServer running with its current state if the callback
+	// has panicked. Implement GenServerPanicHandler to get the recovered value and
+	// the stack trace. By default, the panic terminates the process.
+	RecoverFromPanic bool
+	parent           *Process
 }
 
 // ProcessExitFunc initiate a graceful stopping process
@@ -97,6 +175,18 @@ func (p *Process) Name() string {
 	return p.name
 }
 
+// Reductions returns the number of messages have been processed by this process.
+// It's safe to call it from any goroutine.
+func (p *Process) Reductions() uint64 {
+	return atomic.LoadUint64(&p.reductions)
+}
+
+// ResetReductions resets the reductions counter and returns the value it had
+// before resetting. Useful to compute the processing rate over an interval.
+func (p *Process) ResetReductions() uint64 {
+	return atomic.SwapUint64(&p.reductions, 0)
+}
+
 // Info returns detailed information about the process
 func (p *Process) Info() ProcessInfo {
 	gl := p.self
@@ -109,15 +199,15 @@ func (p *Process) Info() ProcessInfo {
 	return ProcessInfo{
 		PID:             p.self,
 		Name:            p.name,
-		CurrentFunction: p.currentFunction,
+		CurrentFunction: p.getCurrentFunction(),
 		GroupLeader:     gl,
 		Links:           links,
 		Monitors:        monitors,
 		MonitoredBy:     monitoredBy,
 		Status:          "running",
-		MessageQueueLen: len(p.mailBox),
-		TrapExit:        p.trapExit,
-		Reductions:      p.reductions,
+		MessageQueueLen: len(p.mailBox) + len(p.mailBoxPriority),
+		TrapExit:        p.GetTrapExit(),
+		Reductions:      p.Reductions(),
 	}
 }
 
@@ -131,15 +221,27 @@ func (p *Process) Call(to interface{}, message etf.Term) (etf.Term, error) {
 func (p *Process) CallWithTimeout(to interface{}, message etf.Term, timeout int) (etf.Term, error) {
 	var timer *time.Timer
 
+	if atomic.LoadInt32(&p.inCallback) == 1 && p.isSelf(to) {
+		return nil, ErrSelfCall
+	}
+
 	ref := p.Node.MakeRef()
 	from := etf.Tuple{p.self, ref}
 	msg := etf.Term(etf.Tuple{etf.Atom("$gen_call"), from, message})
-	p.Send(to, msg)
+	if err := p.SendWithError(to, msg); err != nil {
+		return nil, err
+	}
 
 	timer = lib.TakeTimer()
 	defer lib.ReleaseTimer(timer)
 	timer.Reset(time.Second * time.Duration(timeout))
 
+	if atomic.LoadInt32(&p.inCallback) == 1 {
+		// the loop is busy running this callback, so we have to
+		// pick the reply out of the mailbox by ourselves
+		return p.waitReplyInline(ref, timer)
+	}
+
 	for {
 		select {
 		case m := <-p.reply:
@@ -158,6 +260,187 @@ func (p *Process) CallWithTimeout(to interface{}, message etf.Term, timeout int)
 	}
 }
 
+// waitReplyInline waits for the reply with the given ref reading the mailbox directly.
+// All the other messages are put aside in order to be handled by the loop later.
+func (p *Process) waitReplyInline(ref etf.Ref, timer *time.Timer) (etf.Term, error) {
+	for {
+		var msg etf.Tuple
+		select {
+		case msg = <-p.mailBoxPriority:
+		case msg = <-p.mailBox:
+		case <-timer.C:
+			return nil, fmt.Errorf("timeout")
+		case <-p.Context.Done():
+			return nil, fmt.Errorf("stopped")
+		}
+
+		if m, ok := msg.Element(2).(etf.Tuple); ok && len(m) == 2 {
+			ref1, ok := m.Element(1).(etf.Ref)
+			if ok && len(ref1.ID) == 3 && ref.ID[0] == ref1.ID[0] && ref.ID[1] == ref1.ID[1] && ref.ID[2] == ref1.ID[2] {
+				return m.Element(2), nil
+			}
+		}
+		p.pending = append(p.pending, msg)
+	}
+}
+
+// putReply puts the reply into the reply channel without blocking. If the channel
+// is full the oldest reply is dropped - nobody is waiting for it anymore.
+func (p *Process) putReply(m etf.Tuple) {
+	for {
+		select {
+		case p.reply <- m:
+			return
+		default:
+		}
+		select {
+		case <-p.reply:
+		default:
+		}
+	}
+}
+
+// ReceiveMatch waits for the first message the 'match' function returns true for
+// (selective receive). Returns ErrTimeout if there was no such message for the given
+// timeout. The mailbox is scanned from the beginning and the non-matching messages
+// are kept in order to be handled after the callback. It must be called within
+// the GenServer callback of the process spawned with DirectDispatch enabled (see
+// ProcessOptions). Otherwise the loop dispatches the messages on its own, so
+// ErrNoDirectDispatch is returned.
+func (p *Process) ReceiveMatch(match func(etf.Term) bool, timeout time.Duration) (etf.Term, error) {
+	if atomic.LoadInt32(&p.inCallback) == 0 {
+		return nil, ErrNoDirectDispatch
+	}
+
+	timer := lib.TakeTimer()
+	defer lib.ReleaseTimer(timer)
+	timer.Reset(timeout)
+
+	return p.receiveMatchInline(match, timer)
+}
+
+func (p *Process) receiveMatchInline(match func(etf.Term) bool, timer *time.Timer) (etf.Term, error) {
+	for i := range p.pending {
+		message := p.pending[i].Element(2)
+		if match(message) {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			return message, nil
+		}
+	}
+
+	for {
+		var msg etf.Tuple
+		select {
+		case msg = <-p.mailBoxPriority:
+		case msg = <-p.mailBox:
+		case <-timer.C:
+			return nil, ErrTimeout
+		case <-p.Context.Done():
+			return nil, fmt.Errorf("stopped")
+		}
+
+		if match(msg.Element(2)) {
+			return msg.Element(2), nil
+		}
+		p.pending = append(p.pending, msg)
+	}
+}
+
+// deliver puts the message into the mailbox according to the overflow policy
+func (p *Process) deliver(from etf.Pid, message etf.Term) error {
+	mailBox := p.mailBox
+	if p.isPrioritySender(from) {
+		mailBox = p.mailBoxPriority
+	}
+	msg := etf.Tuple{from, message}
+
+	select {
+	case mailBox <- msg:
+		return nil
+	default:
+	}
+
+	switch p.overflow {
+	case MailboxOverflowFail:
+		return ErrMailboxFull
+
+	case MailboxOverflowDropOldest:
+		for {
+			select {
+			case mailBox <- msg:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-mailBox:
+				p.dropMessage(dropped.Element(1).(etf.Pid))
+			default:
+			}
+		}
+
+	case MailboxOverflowBlock:
+		select {
+		case mailBox <- msg:
+		case <-p.Context.Done():
+		}
+		return nil
+	}
+
+	// MailboxOverflowDropNewest
+	p.dropMessage(from)
+	return nil
+}
+
+func (p *Process) dropMessage(from etf.Pid) {
+	if p.overflowNotify {
+		atomic.AddInt64(&p.dropped, 1)
+		return
+	}
+	fmt.Println("WARNING! mailbox of", p.Self(), "is full. dropped message from", from)
+}
+
+// isSelf returns true if 'to' refers to this process
+func (p *Process) isSelf(to interface{}) bool {
+	switch t := to.(type) {
+	case etf.Pid:
+		return t == p.self
+	case string:
+		return p.name != "" && t == p.name
+	case etf.Atom:
+		return p.name != "" && string(t) == p.name
+	}
+	return false
+}
+
+// Reply sends the reply to the caller. 'from' is the value HandleCall has been
+// invoked with. Use it to answer the call later (e.g. from HandleInfo) if HandleCall
+// has returned "noreply". If there is no answer the caller gets a timeout error.
+func (p *Process) Reply(from etf.Tuple, reply etf.Term) {
+	pid := from.Element(1).(etf.Pid)
+	ref := from.Element(2)
+	p.Send(pid, etf.Tuple{ref, reply})
+}
+
+// CallInto makes outgoing sync request in fashion of 'gen_call' and decodes the reply
+// into the value pointed to by 'out' using etf.TermIntoStruct. Returns an error if
+// the shape of the reply doesn't match 'out'.
+func (p *Process) CallInto(to interface{}, message etf.Term, out interface{}) error {
+	return p.CallIntoWithTimeout(to, message, out, DefaultCallTimeout)
+}
+
+// CallIntoWithTimeout makes outgoing sync request in fashion of 'gen_call' with given timeout
+// and decodes the reply into the value pointed to by 'out'
+func (p *Process) CallIntoWithTimeout(to interface{}, message etf.Term, out interface{}, timeout int) error {
+	reply, err := p.CallWithTimeout(to, message, timeout)
+	if err != nil {
+		return err
+	}
+	if err := etf.TermIntoStruct(reply, out); err != nil {
+		return fmt.Errorf("can't decode reply: %s", err)
+	}
+	return nil
+}
+
 // CallRPC evaluate rpc call with given node/MFA
 func (p *Process) CallRPC(node, module, function string, args ...etf.Term) (etf.Term, error) {
 	return p.CallRPCWithTimeout(DefaultCallTimeout, node, module, function, args...)
@@ -196,9 +479,18 @@ func (p *Process) Send(to interface{}, message etf.Term) {
 	p.Node.registrar.route(p.self, to, message)
 }
 
+// SendWithError sends a message the same way Send does. Returns ErrMailboxFull if the mailbox
+// of the local recipient is full and its overflow policy is MailboxOverflowFail.
+func (p *Process) SendWithError(to interface{}, message etf.Term) error {
+	return p.Node.registrar.route(p.self, to, message)
+}
+
 // SendAfter starts a timer. When the timer expires, the message sends to the process identified by 'to'.
 // 'to' can be a Pid, registered local name or a tuple {RegisteredName, NodeName}.
-// Returns cancel function in order to discard sending a message
+// Returns cancel function in order to discard sending a message. The timer is bound
+// to the lifetime of this process and is discarded automatically once the process
+// has terminated, so a restarted process never gets messages scheduled by its
+// previous instance. Use SendAfterTimer to get the timer that can be reset.
 func (p *Process) SendAfter(to interface{}, message etf.Term, after time.Duration) context.CancelFunc {
 	//TODO: should we control the number of timers/goroutines have been created this way?
 	ctx, cancel := context.WithCancel(p.Context)
@@ -218,6 +510,110 @@ func (p *Process) SendAfter(to interface{}, message etf.Term, after time.Duratio
 	return cancel
 }
 
+// Timer is the handle of the message scheduled by SendAfterTimer. It can be stopped,
+// rescheduled and inspected for the remaining time. The timer is bound to the lifetime of
+// the process that has started it the same way SendAfter does.
+type Timer struct {
+	sync.Mutex
+	process  *Process
+	to       interface{}
+	message  etf.Term
+	timer    *time.Timer
+	deadline time.Time
+	active   bool
+	// generation of the timer. callback of the stopped or rescheduled timer does nothing
+	gen uint64
+}
+
+// SendAfterTimer starts a timer the same way SendAfter does, but returns the handle
+// of the timer instead of the cancel function. It's handy for implementing the idle timeouts
+// that are bumped (see Timer.Reset) on each message.
+func (p *Process) SendAfterTimer(to interface{}, message etf.Term, after time.Duration) *Timer {
+	t := &Timer{
+		process: p,
+		to:      to,
+		message: message,
+	}
+	t.Reset(after)
+	return t
+}
+
+// Reset reschedules the message to be sent after the given duration. Returns true if the timer
+// had been active, false if it had expired or been stopped. Reset of the expired or stopped
+// timer schedules the message once again.
+func (t *Timer) Reset(after time.Duration) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	active := t.active
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.gen++
+	gen := t.gen
+	t.active = true
+	t.deadline = time.Now().Add(after)
+	t.timer = time.AfterFunc(after, func() {
+		t.fire(gen)
+	})
+	return active
+}
+
+// Stop prevents the message from being sent. Returns false if the timer has already
+// expired or been stopped.
+func (t *Timer) Stop() bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.active {
+		return false
+	}
+	t.active = false
+	t.gen++
+	t.timer.Stop()
+	return true
+}
+
+// Remaining returns the time left until the message is sent. Returns 0 if the timer
+// has expired or been stopped.
+func (t *Timer) Remaining() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.active {
+		return 0
+	}
+	if left := time.Until(t.deadline); left > 0 {
+		return left
+	}
+	return 0
+}
+
+func (t *Timer) fire(gen uint64) {
+	t.Lock()
+	if !t.active || t.gen != gen {
+		// stopped or rescheduled
+		t.Unlock()
+		return
+	}
+	t.active = false
+	t.Unlock()
+
+	if t.process.Context.Err() != nil {
+		// the owner has terminated
+		return
+	}
+	t.process.Node.registrar.route(t.process.self, t.to, t.message)
+}
+
+// Continue schedules the given message to be handled by HandleContinue callback
+// of the GenServer (see GenServerContinue) right after the current callback returns
+// and ahead of any other message in the mailbox. Must be called within the Init
+// or any other callback of the GenServer.
+func (p *Process) Continue(message etf.Term) {
+	p.continuations = append(p.continuations, message)
+}
+
 // CastAfter simple wrapper for SendAfter to send '$gen_cast' message
 func (p *Process) CastAfter(to interface{}, message etf.Term, after time.Duration) context.CancelFunc {
 	msg := etf.Term(etf.Tuple{etf.Atom("$gen_cast"), message})
@@ -232,6 +628,13 @@ func (p *Process) Cast(to interface{}, message etf.Term) {
 	p.Node.registrar.route(p.self, to, msg)
 }
 
+// CastWithError sends a message in fashion of 'gen_cast'. Returns ErrMailboxFull
+// the same way SendWithError does.
+func (p *Process) CastWithError(to interface{}, message etf.Term) error {
+	msg := etf.Term(etf.Tuple{etf.Atom("$gen_cast"), message})
+	return p.Node.registrar.route(p.self, to, msg)
+}
+
 // MonitorProcess creates monitor between the processes.
 // 'process' value can be: etf.Pid, registered local name etf.Atom or
 // remote registered name etf.Tuple{Name etf.Atom, Node etf.Atom}
@@ -262,6 +665,15 @@ func (p *Process) MonitorNode(name string) etf.Ref {
 	return p.Node.monitor.MonitorNode(p.self, name)
 }
 
+// MonitorNodes subscribes the process to the status change of the connections
+// with the other nodes (or unsubscribes if enable is false). The messages
+// {nodeup, Node, Info} and {nodedown, Node, Info} are delivered to the process,
+// where Info is [{node_type, visible | hidden}, {flags, Flags}] and Flags are
+// the distribution flags the remote node has reported during the handshake.
+func (p *Process) MonitorNodes(enable bool) {
+	p.Node.monitor.MonitorNodes(p.self, enable)
+}
+
 // DemonitorProcess removes monitor. Returns false if the given reference 'ref' wasn't found
 func (p *Process) DemonitorProcess(ref etf.Ref) bool {
 	return p.Node.monitor.DemonitorProcess(ref)
@@ -317,6 +729,31 @@ func (p *Process) GetEnv(name string) interface{} {
 	return nil
 }
 
+// SetPrioritySender marks the given pid as a priority sender. Messages from the priority
+// senders are handled ahead of the other messages in the mailbox. It makes sense
+// for the GenServer-based processes only (GenServer, GenStage).
+func (p *Process) SetPrioritySender(pid etf.Pid) {
+	p.Lock()
+	defer p.Unlock()
+	if p.prioritySenders == nil {
+		p.prioritySenders = make(map[etf.Pid]bool)
+	}
+	p.prioritySenders[pid] = true
+}
+
+// RemovePrioritySender removes the given pid from the list of priority senders
+func (p *Process) RemovePrioritySender(pid etf.Pid) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.prioritySenders, pid)
+}
+
+func (p *Process) isPrioritySender(pid etf.Pid) bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.prioritySenders[pid]
+}
+
 // Wait waits until process stopped
 func (p *Process) Wait() {
 	<-p.stopped
@@ -361,18 +798,67 @@ func (p *Process) GetState() string {
 	return fmt.Sprintf("%#v", p.state)
 }
 
+// Direct makes a direct request to the GenServer (see GenServerDirect) and
+// returns the result of its HandleDirect callback.
+func (p *Process) Direct(request interface{}) (interface{}, error) {
+	return p.directRequest("direct", request)
+}
+
+// TriggerCodeChange invokes CodeChange callback of the GenServer (see GenServerCodeChange)
+// in order to migrate its state. Returns the error CodeChange has returned.
+func (p *Process) TriggerCodeChange(oldVsn, extra interface{}) error {
+	_, err := p.directRequest("codeChange", []interface{}{oldVsn, extra})
+	return err
+}
+
 // SetTrapExit enables/disables the trap on terminate process
 func (p *Process) SetTrapExit(trap bool) {
-	p.trapExit = trap
+	if trap {
+		atomic.StoreInt32(&p.trapExit, 1)
+		return
+	}
+	atomic.StoreInt32(&p.trapExit, 0)
 }
 
 // GetTrapExit returns whether the trap was enabled on this process
 func (p *Process) GetTrapExit() bool {
-	return p.trapExit
+	return atomic.LoadInt32(&p.trapExit) == 1
+}
+
+// Stats returns the runtime statistics of the GenServer. Unlike Info it's served
+// by the process itself. The GenServer answers it even if the callback is running
+// (unless it has been started with DirectDispatch).
+func (p *Process) Stats() (ProcessStats, error) {
+	s, err := p.directRequest("getStats", nil)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	return s.(ProcessStats), nil
+}
+
+func (p *Process) stats() ProcessStats {
+	return ProcessStats{
+		Reductions:      p.Reductions(),
+		MessageQueueLen: len(p.mailBox) + len(p.mailBoxPriority),
+		CurrentFunction: p.getCurrentFunction(),
+		TrapExit:        p.GetTrapExit(),
+	}
+}
+
+func (p *Process) setCurrentFunction(name string) {
+	p.currentFunction.Store(name)
+}
+
+func (p *Process) getCurrentFunction() string {
+	if name, ok := p.currentFunction.Load().(string); ok {
+		return name
+	}
+	return ""
 }
 
 func (p *Process) directRequest(id string, request interface{}) (interface{}, error) {
-	reply := make(chan directMessage)
+	// buffered. the process shouldn't get stuck replying if we have timed out
+	reply := make(chan directMessage, 1)
 	t := time.Second * time.Duration(5)
 	m := directMessage{
 		id:      id,
@@ -0,0 +1,424 @@
+package ergo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+type testProcessGS struct {
+	GenServer
+	v chan interface{}
+}
+
+func (tgs *testProcessGS) Init(p *Process, args ...interface{}) interface{} {
+	return nil
+}
+func (tgs *testProcessGS) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testProcessGS) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testProcessGS) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testProcessGS) Terminate(reason string, state interface{}) {
+}
+
+func TestProcessSendAfter(t *testing.T) {
+	fmt.Printf("\n=== Test Process SendAfter\n")
+	fmt.Printf("Starting node: nodeProcessSendAfter@localhost: ")
+	node := CreateNode("nodeProcessSendAfter@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs1 := &testProcessGS{
+		v: make(chan interface{}, 2),
+	}
+	p1, err := node.Spawn("gs1", ProcessOptions{}, gs1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := node.Spawn("gs2", ProcessOptions{}, &testProcessGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    message is delivered once the timer expires: ")
+	p2.SendAfter(p1.Self(), etf.Atom("timer1"), 100*time.Millisecond)
+	waitForResultWithValue(t, gs1.v, etf.Atom("timer1"))
+
+	fmt.Printf("    canceled timer is discarded: ")
+	cancel := p2.SendAfter(p1.Self(), etf.Atom("timer2"), 100*time.Millisecond)
+	cancel()
+	waitForTimeout(t, gs1.v)
+	fmt.Println("OK")
+
+	fmt.Printf("    timers are discarded on termination of the owner: ")
+	p2.SendAfter(p1.Self(), etf.Atom("timer3"), 100*time.Millisecond)
+	p2.Exit(p2.Self(), "normal")
+	if err := p2.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitForTimeout(t, gs1.v)
+	fmt.Println("OK")
+}
+
+func TestProcessSendAfterTimer(t *testing.T) {
+	fmt.Printf("\n=== Test Process SendAfterTimer\n")
+	fmt.Printf("Starting node: nodeProcessSendAfterTimer@localhost: ")
+	node := CreateNode("nodeProcessSendAfterTimer@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs1 := &testProcessGS{
+		v: make(chan interface{}, 2),
+	}
+	p1, err := node.Spawn("gs1", ProcessOptions{}, gs1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := node.Spawn("gs2", ProcessOptions{}, &testProcessGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    message is delivered once the timer expires: ")
+	timer := p2.SendAfterTimer(p1.Self(), etf.Atom("timer1"), 100*time.Millisecond)
+	if r := timer.Remaining(); r <= 0 || r > 100*time.Millisecond {
+		t.Fatal("unexpected remaining time", r)
+	}
+	waitForResultWithValue(t, gs1.v, etf.Atom("timer1"))
+	if r := timer.Remaining(); r != 0 {
+		t.Fatal("expected 0 remaining time of the expired timer, got", r)
+	}
+
+	fmt.Printf("    stopped timer is discarded: ")
+	timer = p2.SendAfterTimer(p1.Self(), etf.Atom("timer2"), 100*time.Millisecond)
+	if !timer.Stop() {
+		t.Fatal("expected true on stopping the active timer")
+	}
+	if timer.Stop() {
+		t.Fatal("expected false on stopping the stopped timer")
+	}
+	waitForTimeout(t, gs1.v)
+	fmt.Println("OK")
+
+	fmt.Printf("    reset postpones the message: ")
+	timer = p2.SendAfterTimer(p1.Self(), etf.Atom("timer3"), 150*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if !timer.Reset(300 * time.Millisecond) {
+		t.Fatal("expected true on resetting the active timer")
+	}
+	select {
+	case m := <-gs1.v:
+		t.Fatal("unexpected message", m)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if r := timer.Remaining(); r <= 0 || r > 100*time.Millisecond {
+		t.Fatal("unexpected remaining time", r)
+	}
+	waitForResultWithValue(t, gs1.v, etf.Atom("timer3"))
+
+	fmt.Printf("    reset of the expired timer schedules the message again: ")
+	if timer.Reset(50 * time.Millisecond) {
+		t.Fatal("expected false on resetting the expired timer")
+	}
+	waitForResultWithValue(t, gs1.v, etf.Atom("timer3"))
+
+	fmt.Printf("    timers are discarded on termination of the owner: ")
+	timer = p2.SendAfterTimer(p1.Self(), etf.Atom("timer4"), 100*time.Millisecond)
+	p2.Exit(p2.Self(), "normal")
+	if err := p2.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitForTimeout(t, gs1.v)
+	fmt.Println("OK")
+}
+
+func TestProcessReductions(t *testing.T) {
+	fmt.Printf("\n=== Test Process Reductions\n")
+	fmt.Printf("Starting node: nodeProcessReductions@localhost: ")
+	node := CreateNode("nodeProcessReductions@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs1 := &testProcessGS{
+		v: make(chan interface{}, 2),
+	}
+	p1, err := node.Spawn("gs1", ProcessOptions{}, gs1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    counting processed messages: ")
+	for i := 0; i < 3; i++ {
+		p1.Send(p1.Self(), i)
+		waitForResultWithValue(t, gs1.v, i)
+	}
+	if r := p1.Reductions(); r != 3 {
+		t.Fatal("expected 3 reductions, got", r)
+	}
+	if r := p1.Info().Reductions; r != 3 {
+		t.Fatal("expected 3 reductions in process info, got", r)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    resetting the counter: ")
+	if r := p1.ResetReductions(); r != 3 {
+		t.Fatal("expected 3 reductions, got", r)
+	}
+	if r := p1.Reductions(); r != 0 {
+		t.Fatal("expected 0 reductions, got", r)
+	}
+	fmt.Println("OK")
+}
+
+type testPrioritySenderGS struct {
+	testProcessGS
+}
+
+func (tgs *testPrioritySenderGS) Init(p *Process, args ...interface{}) interface{} {
+	// the loop isn't started yet so the messages are kept in the mailboxes
+	sender := args[0].(*Process)
+	p.SetPrioritySender(sender.Self())
+	p.Send(p.Self(), etf.Atom("regular"))
+	sender.Send(p.Self(), etf.Atom("priority"))
+	tgs.v <- etf.Tuple{len(p.mailBox), len(p.mailBoxPriority)}
+	return nil
+}
+
+func TestProcessPrioritySender(t *testing.T) {
+	fmt.Printf("\n=== Test Process priority sender\n")
+	fmt.Printf("Starting node: nodeProcessPriority@localhost: ")
+	node := CreateNode("nodeProcessPriority@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	sender, err := node.Spawn("sender", ProcessOptions{}, &testProcessGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gs1 := &testPrioritySenderGS{}
+	gs1.v = make(chan interface{}, 3)
+	p1, err := node.Spawn("gs1", ProcessOptions{}, gs1, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("    message from the priority sender is queued separately: ")
+	waitForResultWithValue(t, gs1.v, etf.Tuple{1, 1})
+
+	fmt.Printf("    all the messages are delivered: ")
+	waitForResultWithMultiValue(t, gs1.v, etf.List{etf.Atom("regular"), etf.Atom("priority")})
+
+	fmt.Printf("    removing priority sender: ")
+	p1.RemovePrioritySender(sender.Self())
+	if p1.isPrioritySender(sender.Self()) {
+		t.Fatal("sender is still a priority one")
+	}
+	fmt.Println("OK")
+}
+
+type testMailboxOverflowGS struct {
+	GenServer
+	v       chan interface{}
+	release chan bool
+}
+
+func (tgs *testMailboxOverflowGS) Init(p *Process, args ...interface{}) interface{} {
+	return nil
+}
+func (tgs *testMailboxOverflowGS) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testMailboxOverflowGS) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testMailboxOverflowGS) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.v <- message
+	if message == etf.Atom("block") {
+		<-tgs.release
+	}
+	return "noreply", state
+}
+func (tgs *testMailboxOverflowGS) Terminate(reason string, state interface{}) {
+}
+
+func TestProcessMailboxOverflow(t *testing.T) {
+	fmt.Printf("\n=== Test Process MailboxOverflow\n")
+	fmt.Printf("Starting node: nodeProcessMailboxOverflow@localhost: ")
+	node := CreateNode("nodeProcessMailboxOverflow@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	sender, err := node.Spawn("gsSender", ProcessOptions{}, &testProcessGS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// DirectDispatch makes the loop wait for the blocked callback
+	// so the mailbox is filled up
+	spawn := func(name string, overflow MailboxOverflow, notify bool) (*testMailboxOverflowGS, *Process) {
+		gs := &testMailboxOverflowGS{
+			v:       make(chan interface{}, 10),
+			release: make(chan bool),
+		}
+		opts := ProcessOptions{
+			MailboxSize:           1,
+			DirectDispatch:        true,
+			MailboxOverflow:       overflow,
+			MailboxOverflowNotify: notify,
+		}
+		p, err := node.Spawn(name, opts, gs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender.Send(p.Self(), etf.Atom("block"))
+		if v := <-gs.v; v != etf.Atom("block") {
+			t.Fatal("unexpected message", v)
+		}
+		if err := sender.SendWithError(p.Self(), etf.Atom("m1")); err != nil {
+			t.Fatal(err)
+		}
+		return gs, p
+	}
+
+	fmt.Printf("    MailboxOverflowFail returns ErrMailboxFull: ")
+	gs, p := spawn("gsFail", MailboxOverflowFail, false)
+	if err := sender.SendWithError(p.Self(), etf.Atom("m2")); err != ErrMailboxFull {
+		t.Fatal("expected ErrMailboxFull, got", err)
+	}
+	if err := sender.CastWithError(p.Self(), etf.Atom("m2")); err != ErrMailboxFull {
+		t.Fatal("expected ErrMailboxFull, got", err)
+	}
+	fmt.Println("OK")
+	gs.release <- true
+	fmt.Printf("    message from the mailbox: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("m1"))
+
+	fmt.Printf("    MailboxOverflowDropOldest drops the oldest one and notifies: ")
+	gs, p = spawn("gsDropOldest", MailboxOverflowDropOldest, true)
+	sender.Send(p.Self(), etf.Atom("m2"))
+	gs.release <- true
+	waitForResultWithValue(t, gs.v, MessageMailboxOverflow{Dropped: 1})
+	fmt.Printf("    the newest message is kept: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("m2"))
+
+	fmt.Printf("    MailboxOverflowDropNewest drops the new one and notifies: ")
+	gs, p = spawn("gsDropNewest", MailboxOverflowDropNewest, true)
+	sender.Send(p.Self(), etf.Atom("m2"))
+	sender.Send(p.Self(), etf.Atom("m3"))
+	gs.release <- true
+	waitForResultWithValue(t, gs.v, MessageMailboxOverflow{Dropped: 2})
+	fmt.Printf("    the oldest message is kept: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("m1"))
+
+	fmt.Printf("    default policy drops the new one: ")
+	gs, p = spawn("gsDefault", "", false)
+	sent := make(chan interface{}, 1)
+	go func() {
+		sender.Send(p.Self(), etf.Atom("m2"))
+		sent <- etf.Atom("sent")
+	}()
+	waitForResultWithValue(t, sent, etf.Atom("sent"))
+	gs.release <- true
+	fmt.Printf("    the oldest message is kept: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("m1"))
+	fmt.Printf("    the new one is dropped: ")
+	waitForTimeout(t, gs.v)
+	fmt.Println("OK")
+
+	fmt.Printf("    MailboxOverflowBlock blocks the sender: ")
+	gs, p = spawn("gsBlock", MailboxOverflowBlock, false)
+	go func() {
+		sender.Send(p.Self(), etf.Atom("m2"))
+		sent <- etf.Atom("sent")
+	}()
+	select {
+	case <-sent:
+		t.Fatal("sender is not blocked")
+	case <-time.After(100 * time.Millisecond):
+		fmt.Println("OK")
+	}
+	gs.release <- true
+	fmt.Printf("    sender is unblocked: ")
+	waitForResultWithValue(t, sent, etf.Atom("sent"))
+	fmt.Printf("    message from the mailbox: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("m1"))
+	fmt.Printf("    blocked message: ")
+	waitForResultWithValue(t, gs.v, etf.Atom("m2"))
+}
+
+func TestProcessStats(t *testing.T) {
+	fmt.Printf("\n=== Test Process Stats\n")
+	fmt.Printf("Starting node: nodeProcessStats@localhost: ")
+	node := CreateNode("nodeProcessStats@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	gs := &testMailboxOverflowGS{
+		v:       make(chan interface{}, 10),
+		release: make(chan bool),
+	}
+	p, err := node.Spawn("gsStats", ProcessOptions{}, gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTrapExit(true)
+
+	fmt.Printf("    stats of the idle process: ")
+	stats, err := p.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ProcessStats{
+		CurrentFunction: "GenServer:loop",
+		TrapExit:        true,
+	}
+	if stats != expected {
+		t.Fatal("unexpected stats", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    stats of the process running a callback: ")
+	p.Send(p.Self(), etf.Atom("block"))
+	<-gs.v
+	stats, err = p.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = ProcessStats{
+		Reductions:      1,
+		CurrentFunction: "GenServer:HandleInfo",
+		TrapExit:        true,
+	}
+	if stats != expected {
+		t.Fatal("unexpected stats", stats)
+	}
+	gs.release <- true
+	fmt.Println("OK")
+}
@@ -2,7 +2,6 @@ package ergo
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"sync/atomic"
 
@@ -83,19 +82,24 @@ func (r *registrar) RegisterProcessExt(name string, object interface{}, opts Pro
 	exitChannel := make(chan gracefulExitRequest)
 
 	process := &Process{
-		mailBox:      make(chan etf.Tuple, mailboxSize),
-		ready:        make(chan error),
-		stopped:      make(chan bool),
-		gracefulExit: exitChannel,
-		direct:       make(chan directMessage),
-		self:         pid,
-		groupLeader:  opts.GroupLeader,
-		Context:      ctx,
-		Kill:         kill,
-		name:         name,
-		Node:         r.node,
-		reply:        make(chan etf.Tuple, 2),
-		object:       object,
+		mailBox:          make(chan etf.Tuple, mailboxSize),
+		mailBoxPriority:  make(chan etf.Tuple, mailboxSize),
+		ready:            make(chan error),
+		stopped:          make(chan bool),
+		gracefulExit:     exitChannel,
+		direct:           make(chan directMessage),
+		self:             pid,
+		groupLeader:      opts.GroupLeader,
+		Context:          ctx,
+		Kill:             kill,
+		name:             name,
+		Node:             r.node,
+		reply:            make(chan etf.Tuple, 2),
+		object:           object,
+		directDispatch:   opts.DirectDispatch,
+		overflow:         opts.MailboxOverflow,
+		overflowNotify:   opts.MailboxOverflowNotify,
+		recoverFromPanic: opts.RecoverFromPanic,
 	}
 
 	exit := func(from etf.Pid, reason string) {
@@ -108,7 +112,7 @@ func (r *registrar) RegisterProcessExt(name string, object interface{}, opts Pro
 			// process is already died
 			return
 		}
-		if process.trapExit {
+		if process.GetTrapExit() {
 			message := etf.Tuple{from, etf.Tuple{
 				etf.Atom("EXIT"),
 				from,
@@ -297,9 +301,11 @@ func (r *registrar) ProcessList() []*Process {
 
 func (r *registrar) PeerList() []string {
 	list := []string{}
+	r.mutexPeers.Lock()
 	for n, _ := range r.peers {
 		list = append(list, n)
 	}
+	r.mutexPeers.Unlock()
 	return list
 }
 
@@ -314,7 +320,7 @@ func (r *registrar) ApplicationList() []*ApplicationSpec {
 }
 
 // route routes message to a local/remote process
-func (r *registrar) route(from etf.Pid, to etf.Term, message etf.Term) {
+func (r *registrar) route(from etf.Pid, to etf.Term, message etf.Term) error {
 next:
 	switch tto := to.(type) {
 	case etf.Pid:
@@ -322,16 +328,14 @@ next:
 		if string(tto.Node) == r.nodeName {
 			// local route
 			r.mutexProcesses.Lock()
-			if p, ok := r.processes[tto.ID]; ok {
-				select {
-				case p.mailBox <- etf.Tuple{from, message}:
-
-				default:
-					fmt.Println("WARNING! mailbox of", p.Self(), "is full. dropped message from", from)
-				}
-			}
+			p, ok := r.processes[tto.ID]
 			r.mutexProcesses.Unlock()
-			return
+			if !ok {
+				return nil
+			}
+			// do not hold the lock here. delivering could take a while
+			// if the mailbox is full (see MailboxOverflowBlock)
+			return p.deliver(from, message)
 		}
 
 		r.mutexPeers.Lock()
@@ -340,7 +344,7 @@ next:
 		if !ok {
 			if err := r.node.connect(tto.Node); err != nil {
 				lib.Log("[%s] can't connect to %v: %s", r.node.FullName, tto.Node, err)
-				return
+				return err
 			}
 
 			r.mutexPeers.Lock()
@@ -348,8 +352,9 @@ next:
 			r.mutexPeers.Unlock()
 		}
 
-		send := peer.GetChannel()
-		send <- []etf.Term{etf.Tuple{distProtoSEND, etf.Atom(""), tto}, message}
+		if peer == nil || !peer.Send([]etf.Term{etf.Tuple{distProtoSEND, etf.Atom(""), tto}, message}) {
+			lib.Log("[%s] can't send message. peer %v is disconnected", r.node.FullName, tto.Node)
+		}
 
 	case etf.Tuple:
 		lib.Log("[%s] sending message by tuple %v", r.node.FullName, tto)
@@ -380,8 +385,7 @@ next:
 
 		if toNode == etf.Atom(r.nodeName) {
 			// local route
-			r.route(from, toProcessName, message)
-			return
+			return r.route(from, toProcessName, message)
 		}
 
 		r.mutexPeers.Lock()
@@ -391,7 +395,7 @@ next:
 			// initiate connection and make yet another attempt to deliver this message
 			if err := r.node.connect(toNode); err != nil {
 				lib.Log("[%s] can't connect to %v: %s", r.node.FullName, toNode, err)
-				return
+				return err
 			}
 
 			r.mutexPeers.Lock()
@@ -399,8 +403,9 @@ next:
 			r.mutexPeers.Unlock()
 		}
 
-		send := peer.GetChannel()
-		send <- []etf.Term{etf.Tuple{distProtoREG_SEND, from, etf.Atom(""), toProcessName}, message}
+		if peer == nil || !peer.Send([]etf.Term{etf.Tuple{distProtoREG_SEND, from, etf.Atom(""), toProcessName}, message}) {
+			lib.Log("[%s] can't send message. peer %v is disconnected", r.node.FullName, toNode)
+		}
 
 	case string:
 		lib.Log("[%s] sending message by name %v", r.node.FullName, tto)
@@ -424,6 +429,7 @@ next:
 	default:
 		lib.Log("[%s] unknow sender type %#v", r.node.FullName, tto)
 	}
+	return nil
 }
 
 func (r *registrar) routeRaw(nodename etf.Atom, message etf.Term) error {
@@ -442,7 +448,8 @@ func (r *registrar) routeRaw(nodename etf.Atom, message etf.Term) error {
 		r.mutexPeers.Unlock()
 	}
 
-	send := peer.GetChannel()
-	send <- []etf.Term{message}
+	if peer == nil || !peer.Send([]etf.Term{message}) {
+		lib.Log("[%s] can't send message. peer %v is disconnected", r.node.FullName, nodename)
+	}
 	return nil
 }
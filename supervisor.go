@@ -2,6 +2,7 @@ package ergo
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/halturin/ergo/etf"
@@ -17,6 +18,8 @@ type SupervisorStrategy struct {
 type SupervisorStrategyType = string
 type SupervisorChildRestart = string
 type SupervisorChild = string
+type SupervisorChildType = string
+type SupervisorChildStatus = string
 
 const (
 	// Restart strategies:
@@ -38,8 +41,9 @@ const (
 	// SupervisorStrategyRestForOne If one child process terminates and is to be restarted,
 	// the 'rest' of the child processes (that is, the child
 	// processes after the terminated child process in the start order)
-	// are terminated. Then the terminated child process and all
-	// child processes after it are restarted
+	// are terminated one by one in the reverse start order. Then the
+	// terminated child process and all child processes after it are
+	// restarted in the start order
 	SupervisorStrategyRestForOne = SupervisorStrategyType("rest_for_one")
 
 	// SupervisorStrategySimpleOneForOne A simplified one_for_one supervisor, where all
@@ -65,20 +69,37 @@ const (
 
 	supervisorChildStateStart    = 0
 	supervisorChildStateRunning  = 1
+	supervisorChildStateWaiting  = 2 // backoff delay before the restart
 	supervisorChildStateDisabled = -1
 
-	// shutdown defines how a child process must be terminated. (TODO: not implemented yet)
+	// shutdown defines how a child process must be terminated.
 
 	// SupervisorChildShutdownBrutal means that the child process is
 	// unconditionally terminated using process' Kill method
 	SupervisorChildShutdownBrutal = -1
 
 	// SupervisorChildShutdownInfinity means that the supervisor will
-	// wait for an exit signal as long as child takes
-	SupervisorChildShutdownInfinity = 0 // default shutdown behaviour
+	// wait for an exit signal as long as child takes (default shutdown behaviour).
+	// It's reserved for the child supervisors, so the tree is shut down bottom-up.
+	// The worker child is given SupervisorChildShutdownTimeout5sec instead.
+	SupervisorChildShutdownInfinity = 0
 
 	// SupervisorChildShutdownTimeout5sec predefined timeout value
 	SupervisorChildShutdownTimeout5sec = 5
+
+	// child types (see WhichChildren)
+
+	SupervisorChildTypeWorker     = SupervisorChildType("worker")
+	SupervisorChildTypeSupervisor = SupervisorChildType("supervisor")
+
+	// child statuses (see WhichChildren)
+
+	// SupervisorChildStatusRunning child process is running
+	SupervisorChildStatusRunning = SupervisorChildStatus("running")
+	// SupervisorChildStatusRestarting child process is terminated and is about to be restarted
+	SupervisorChildStatusRestarting = SupervisorChildStatus("restarting")
+	// SupervisorChildStatusUndefined child process is terminated and won't be restarted
+	SupervisorChildStatusUndefined = SupervisorChildStatus("undefined")
 )
 
 type supervisorChildState int
@@ -104,9 +125,21 @@ type SupervisorSpec struct {
 	Name     string
 	Children []SupervisorChildSpec
 	Strategy SupervisorStrategy
+	Backoff  SupervisorBackoff
 	restarts []int64
 }
 
+// SupervisorBackoff defines the delay between the restarts of the crashing child.
+// The first restart happens immediately, every next one is delayed by
+// Base * Multiplier^(n-1) but not longer than Max. The counter of the crashes
+// is reset once the child stays up for ResetAfter. Zero Base disables the backoff.
+type SupervisorBackoff struct {
+	Base       time.Duration
+	Max        time.Duration
+	Multiplier float64       // default value is 2
+	ResetAfter time.Duration // default value is 10*Base
+}
+
 type SupervisorChildSpec struct {
 	Name     string
 	Child    interface{}
@@ -115,6 +148,33 @@ type SupervisorChildSpec struct {
 	Shutdown SupervisorChildShutdown
 	state    supervisorChildState // for internal usage
 	process  *Process
+
+	// backoff (see SupervisorBackoff)
+	startedAt time.Time
+	crashes   int
+	backoffID uint64
+}
+
+// SupervisorChildInfo describes the child of the supervisor (see WhichChildren).
+// Pid is empty unless Status is SupervisorChildStatusRunning.
+type SupervisorChildInfo struct {
+	Name   string
+	Pid    etf.Pid
+	Status SupervisorChildStatus
+	Type   SupervisorChildType
+}
+
+// SupervisorChildrenCount contains the number of the children of the supervisor (see CountChildren)
+type SupervisorChildrenCount struct {
+	Specs       int
+	Active      int
+	Supervisors int
+	Workers     int
+}
+
+type supervisorTerminatingChild struct {
+	process  *Process
+	shutdown SupervisorChildShutdown
 }
 
 // Supervisor is implementation of ProcessBehaviour interface
@@ -135,15 +195,31 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 	}
 
 	svp.SetTrapExit(true)
-	svp.currentFunction = "Supervisor:loop"
+	svp.setCurrentFunction("Supervisor:loop")
 	waitTerminatingProcesses := []etf.Pid{}
+	// rest_for_one terminates the children one by one
+	terminateQueue := []supervisorTerminatingChild{}
+	// children terminated via TerminateChild. their 'EXIT' must be ignored
+	terminatedByRequest := make(map[etf.Pid]bool)
+	// the backoff delay of one_for_all and rest_for_one restarts
+	restartDelay := time.Duration(0)
+	backoffID := uint64(0)
+	restartChildren := func() {
+		if restartDelay == 0 {
+			startChildren(svp, &spec)
+			return
+		}
+		svp.SendAfter(svp.Self(), etf.Tuple{etf.Atom("$restartChildren")}, restartDelay)
+		restartDelay = 0
+	}
 
 	for {
 		var message etf.Term
 		var fromPid etf.Pid
 		select {
 		case ex := <-svp.gracefulExit:
-			for i := range spec.Children {
+			// terminate children in reverse start order
+			for i := len(spec.Children) - 1; i >= 0; i-- {
 				p := spec.Children[i].process
 				if p == nil {
 					continue
@@ -152,7 +228,7 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 					// in order to get rid of race condition when Node goes down
 					// via node.Stop() cancaling the node's context which
 					// triggering all the processes to kill themselves
-					p.Exit(svp.Self(), ex.reason)
+					shutdownChild(svp.Self(), p, spec.Children[i].shutdown(), ex.reason)
 				}
 			}
 			return ex.reason
@@ -168,7 +244,7 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 			continue
 		}
 
-		svp.reductions++
+		atomic.AddUint64(&svp.reductions, 1)
 
 		lib.Log("[%#v]. Message from %#v\n", svp.self, fromPid)
 
@@ -181,6 +257,10 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 			case etf.Atom("EXIT"):
 				terminated := m.Element(2).(etf.Pid)
 				reason := m.Element(3).(etf.Atom)
+				if terminatedByRequest[terminated] {
+					delete(terminatedByRequest, terminated)
+					continue
+				}
 				itWasChild := false
 				// We should make sure if it was real call for exit.
 				// 'EXIT' message shouldn't be sent by the child of this supervisor
@@ -194,6 +274,21 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 						break
 					}
 				}
+				// the children terminated by the supervisor itself (one_for_all and
+				// rest_for_one restarts) are not in the list anymore. they could
+				// be killed (see SupervisorChildShutdownBrutal) with reason 'kill'
+				for i := range waitTerminatingProcesses {
+					if waitTerminatingProcesses[i] == terminated {
+						itWasChild = true
+						break
+					}
+				}
+				for i := range terminateQueue {
+					if terminateQueue[i].process.Self() == terminated {
+						itWasChild = true
+						break
+					}
+				}
 				if !itWasChild && reason != etf.Atom("restart") {
 					// so we should proceed it as a graceful exit request and
 					// terminate this Application process (if all children will
@@ -217,9 +312,25 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 						}
 					}
 
+					// it could be terminated by itself before we asked it to
+					for i := range terminateQueue {
+						if terminateQueue[i].process.Self() == terminated {
+							terminateQueue = append(terminateQueue[:i], terminateQueue[i+1:]...)
+							break
+						}
+					}
+
+					if len(waitTerminatingProcesses) == 0 && len(terminateQueue) > 0 {
+						next := terminateQueue[0]
+						terminateQueue = terminateQueue[1:]
+						go shutdownChild(next.process.Self(), next.process, next.shutdown, "restart")
+						waitTerminatingProcesses = append(waitTerminatingProcesses, next.process.Self())
+						continue
+					}
+
 					if len(waitTerminatingProcesses) == 0 {
 						// it was the last one. lets restart all terminated children
-						startChildren(svp, &spec)
+						restartChildren()
 					}
 
 					continue
@@ -244,11 +355,12 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 								spec.Children[i].state = supervisorChildStateDisabled
 							} else {
 								spec.Children[i].state = supervisorChildStateStart
+								restartDelay = spec.Backoff.delay(&spec.Children[i])
 							}
 
 							if len(spec.Children) == i+1 && len(waitTerminatingProcesses) == 0 {
 								// it was the last one. nothing to waiting for
-								startChildren(svp, &spec)
+								restartChildren()
 							}
 							continue
 						}
@@ -258,13 +370,14 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 						} else {
 							spec.Children[i].state = supervisorChildStateStart
 						}
-						p.Exit(p.Self(), "restart")
+						go shutdownChild(p.Self(), p, spec.Children[i].shutdown(), "restart")
 
 						waitTerminatingProcesses = append(waitTerminatingProcesses, p.Self())
 					}
 
 				case SupervisorStrategyRestForOne:
 					isRest := false
+					rest := []supervisorTerminatingChild{}
 					for i := range spec.Children {
 						p := spec.Children[i].process
 						if p == nil {
@@ -277,20 +390,17 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 								spec.Children[i].state = supervisorChildStateDisabled
 							} else {
 								spec.Children[i].state = supervisorChildStateStart
+								restartDelay = spec.Backoff.delay(&spec.Children[i])
 							}
-
-							if len(spec.Children) == i+1 && len(waitTerminatingProcesses) == 0 {
-								// it was the last one. nothing to waiting for
-								startChildren(svp, &spec)
-							}
-
 							continue
 						}
 
 						if isRest && spec.Children[i].state == supervisorChildStateRunning {
-							p.Exit(p.Self(), "restart")
+							rest = append(rest, supervisorTerminatingChild{
+								process:  p,
+								shutdown: spec.Children[i].shutdown(),
+							})
 							spec.Children[i].process = nil
-							waitTerminatingProcesses = append(waitTerminatingProcesses, p.Self())
 							if haveToDisableChild(spec.Children[i].Restart, "restart") {
 								spec.Children[i].state = supervisorChildStateDisabled
 							} else {
@@ -299,6 +409,21 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 						}
 					}
 
+					if len(rest) == 0 {
+						// nothing to wait for
+						restartChildren()
+						break
+					}
+
+					// terminate the rest in reverse order (one by one).
+					// they are restarted in the forward order once the last one is terminated
+					for i := len(rest) - 1; i > 0; i-- {
+						terminateQueue = append(terminateQueue, rest[i-1])
+					}
+					last := rest[len(rest)-1]
+					go shutdownChild(last.process.Self(), last.process, last.shutdown, "restart")
+					waitTerminatingProcesses = append(waitTerminatingProcesses, last.process.Self())
+
 				case SupervisorStrategyOneForOne:
 					for i := range spec.Children {
 						p := spec.Children[i].process
@@ -309,10 +434,20 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 							spec.Children[i].process = nil
 							if haveToDisableChild(spec.Children[i].Restart, reason) {
 								spec.Children[i].state = supervisorChildStateDisabled
-							} else {
-								spec.Children[i].state = supervisorChildStateStart
+								startChildren(svp, &spec)
+								break
 							}
 
+							if delay := spec.Backoff.delay(&spec.Children[i]); delay > 0 {
+								// other children are handled while this one is waiting
+								backoffID++
+								spec.Children[i].state = supervisorChildStateWaiting
+								spec.Children[i].backoffID = backoffID
+								svp.SendAfter(svp.Self(), etf.Tuple{etf.Atom("$restartChild"), backoffID}, delay)
+								break
+							}
+
+							spec.Children[i].state = supervisorChildStateStart
 							startChildren(svp, &spec)
 							break
 						}
@@ -326,20 +461,57 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 						}
 						if p.Self() == terminated {
 
-							if haveToDisableChild(spec.Children[i].Restart, reason) {
+							if haveToDisableChild(spec.Children[i].Restart, reason) || svp.Node.isStopping() {
 								// wont be restarted due to restart strategy
+								// or the node is shutting down
 								spec.Children[i] = spec.Children[0]
 								spec.Children = spec.Children[1:]
 								break
 							}
 
+							if delay := spec.Backoff.delay(&spec.Children[i]); delay > 0 {
+								backoffID++
+								spec.Children[i].process = nil
+								spec.Children[i].state = supervisorChildStateWaiting
+								spec.Children[i].backoffID = backoffID
+								svp.SendAfter(svp.Self(), etf.Tuple{etf.Atom("$restartChild"), backoffID}, delay)
+								break
+							}
+
 							process := startChild(svp, spec.Children[i].Name, spec.Children[i].Child, spec.Children[i].Args...)
 							spec.Children[i].process = process
+							spec.Children[i].startedAt = time.Now()
+							break
+						}
+					}
+				}
+
+			case etf.Atom("$restartChild"):
+				// backoff delay is over
+				id := m.Element(2).(uint64)
+				for i := range spec.Children {
+					if spec.Children[i].state != supervisorChildStateWaiting || spec.Children[i].backoffID != id {
+						continue
+					}
+					if spec.Strategy.Type == SupervisorStrategySimpleOneForOne {
+						if svp.Node.isStopping() {
 							break
 						}
+						spec.Children[i].state = supervisorChildStateRunning
+						process := startChild(svp, spec.Children[i].Name, spec.Children[i].Child, spec.Children[i].Args...)
+						spec.Children[i].process = process
+						spec.Children[i].startedAt = time.Now()
+						break
 					}
+					spec.Children[i].state = supervisorChildStateStart
+					startChildren(svp, &spec)
+					break
 				}
 
+			case etf.Atom("$restartChildren"):
+				// backoff delay of one_for_all/rest_for_one is over
+				startChildren(svp, &spec)
+
 			case etf.Atom("$startByName"):
 				// dynamically start child process
 				specName := m.Element(2).(string)
@@ -354,6 +526,14 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 				specChild := *s
 				specChild.process = nil
 				specChild.state = supervisorChildStateStart
+				if spec.Strategy.Type == SupervisorStrategySimpleOneForOne {
+					// extra args are appended to the args of the child spec
+					extra := args.([]interface{})
+					a := make([]interface{}, 0, len(specChild.Args)+len(extra))
+					a = append(a, specChild.Args...)
+					specChild.Args = append(a, extra...)
+					args = []interface{}{}
+				}
 
 				m := etf.Tuple{
 					etf.Atom("$startBySpec"),
@@ -378,6 +558,71 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 				spec.Children = append(spec.Children, specChild)
 
 				reply <- etf.Tuple{etf.Atom("ok"), process.self}
+
+			case etf.Atom("$terminateChild"):
+				name := m.Element(2).(string)
+				reply := m.Element(3).(chan etf.Tuple)
+
+				if spec.Strategy.Type == SupervisorStrategySimpleOneForOne {
+					reply <- etf.Tuple{etf.Atom("error"), "simple_one_for_one"}
+					continue
+				}
+				s := lookupSpecByName(name, spec.Children)
+				if s == nil {
+					reply <- etf.Tuple{etf.Atom("error"), "not_found"}
+					continue
+				}
+				// keep the spec, but do not restart it anymore
+				s.state = supervisorChildStateDisabled
+				if p := s.process; p != nil {
+					s.process = nil
+					terminatedByRequest[p.Self()] = true
+					// it could be waited for the restart
+					for i := range terminateQueue {
+						if terminateQueue[i].process == p {
+							terminateQueue = append(terminateQueue[:i], terminateQueue[i+1:]...)
+							break
+						}
+					}
+					// do not block the supervisor while the child is terminating
+					go func(p *Process, shutdown SupervisorChildShutdown) {
+						shutdownChild(svp.Self(), p, shutdown, "shutdown")
+						reply <- etf.Tuple{etf.Atom("ok")}
+					}(p, s.shutdown())
+					continue
+				}
+				reply <- etf.Tuple{etf.Atom("ok")}
+
+			case etf.Atom("$deleteChild"):
+				name := m.Element(2).(string)
+				reply := m.Element(3).(chan etf.Tuple)
+
+				if spec.Strategy.Type == SupervisorStrategySimpleOneForOne {
+					reply <- etf.Tuple{etf.Atom("error"), "simple_one_for_one"}
+					continue
+				}
+				deleted := false
+				for i := range spec.Children {
+					if spec.Children[i].Name != name {
+						continue
+					}
+					if spec.Children[i].process != nil {
+						break
+					}
+					spec.Children = append(spec.Children[:i], spec.Children[i+1:]...)
+					deleted = true
+					break
+				}
+				if deleted {
+					reply <- etf.Tuple{etf.Atom("ok")}
+					continue
+				}
+				if lookupSpecByName(name, spec.Children) == nil {
+					reply <- etf.Tuple{etf.Atom("error"), "not_found"}
+					continue
+				}
+				reply <- etf.Tuple{etf.Atom("error"), "running"}
+
 			default:
 				lib.Log("m: %#v", m)
 			}
@@ -391,6 +636,8 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 // StartChild dynamically starts a child process with given name of child spec which is defined by Init call.
 // Created process will use the same object (GenServer/Supervisor) you have defined in spec as a Child since it
 // keeps pointer. You might use this object as a shared among the process you will create using this spec.
+// The given args replace the args of the child spec. For the simple_one_for_one supervisor they are
+// appended to the args of the child spec.
 func (sv *Supervisor) StartChild(parent *Process, specName string, args ...interface{}) (etf.Pid, error) {
 	reply := make(chan etf.Tuple)
 	m := etf.Tuple{
@@ -430,8 +677,111 @@ func (sv *Supervisor) StartChildWithSpec(parent *Process, spec SupervisorChildSp
 	}
 }
 
+// TerminateChild terminates the child process with given name of child spec (with reason 'shutdown')
+// and waits until it's terminated. The child spec is kept, but the child won't be restarted.
+// It isn't supported by the simple_one_for_one supervisor.
+func (sv *Supervisor) TerminateChild(parent *Process, name string) error {
+	reply := make(chan etf.Tuple)
+	m := etf.Tuple{
+		etf.Atom("$terminateChild"),
+		name,
+		reply,
+	}
+	parent.mailBox <- etf.Tuple{etf.Pid{}, m}
+	r := <-reply
+	switch r.Element(1) {
+	case etf.Atom("ok"):
+		return nil
+	default:
+		return fmt.Errorf("%s", r.Element(2).(string))
+	}
+}
+
+// DeleteChild deletes the child spec with given name. The child process must be terminated
+// by TerminateChild before. It isn't supported by the simple_one_for_one supervisor.
+func (sv *Supervisor) DeleteChild(parent *Process, name string) error {
+	reply := make(chan etf.Tuple)
+	m := etf.Tuple{
+		etf.Atom("$deleteChild"),
+		name,
+		reply,
+	}
+	parent.mailBox <- etf.Tuple{etf.Pid{}, m}
+	r := <-reply
+	switch r.Element(1) {
+	case etf.Atom("ok"):
+		return nil
+	default:
+		return fmt.Errorf("%s", r.Element(2).(string))
+	}
+}
+
+// WhichChildren returns the list of the children of the supervisor in the start order.
+// The simple_one_for_one supervisor lists the running children only.
+func (sv *Supervisor) WhichChildren(parent *Process) ([]SupervisorChildInfo, error) {
+	children, err := parent.directRequest("whichChildren", nil)
+	if err != nil {
+		return nil, err
+	}
+	return children.([]SupervisorChildInfo), nil
+}
+
+// CountChildren returns the number of the children of the supervisor
+func (sv *Supervisor) CountChildren(parent *Process) (SupervisorChildrenCount, error) {
+	count := SupervisorChildrenCount{}
+	children, err := sv.WhichChildren(parent)
+	if err != nil {
+		return count, err
+	}
+
+	count.Specs = len(children)
+	for i := range children {
+		if children[i].Status == SupervisorChildStatusRunning {
+			count.Active++
+		}
+		if children[i].Type == SupervisorChildTypeSupervisor {
+			count.Supervisors++
+			continue
+		}
+		count.Workers++
+	}
+	return count, nil
+}
+
 func (sv *Supervisor) handleDirect(m directMessage) {
 	switch m.id {
+	case "whichChildren":
+		children := []SupervisorChildInfo{}
+		for i := range sv.spec.Children {
+			child := sv.spec.Children[i]
+			if sv.spec.Strategy.Type == SupervisorStrategySimpleOneForOne &&
+				child.process == nil && child.state != supervisorChildStateWaiting {
+				// child spec or terminated child
+				continue
+			}
+
+			info := SupervisorChildInfo{
+				Name:   child.Name,
+				Status: SupervisorChildStatusUndefined,
+				Type:   SupervisorChildTypeWorker,
+			}
+			if _, ok := child.Child.(SupervisorBehaviour); ok {
+				info.Type = SupervisorChildTypeSupervisor
+			}
+
+			switch {
+			case child.process != nil && child.process.IsAlive():
+				info.Pid = child.process.self
+				info.Status = SupervisorChildStatusRunning
+			case child.state != supervisorChildStateDisabled:
+				info.Status = SupervisorChildStatusRestarting
+			}
+			children = append(children, info)
+		}
+
+		m.message = children
+		m.reply <- m
+
 	case "getChildren":
 		children := []etf.Pid{}
 		for i := range sv.spec.Children {
@@ -453,6 +803,10 @@ func (sv *Supervisor) handleDirect(m directMessage) {
 }
 
 func startChildren(parent *Process, spec *SupervisorSpec) {
+	if parent.Node.isStopping() {
+		// node is shutting down. nothing to restart
+		return
+	}
 	spec.restarts = append(spec.restarts, time.Now().Unix())
 	if len(spec.restarts) > int(spec.Strategy.Intensity) {
 		period := time.Now().Unix() - spec.restarts[0]
@@ -469,12 +823,13 @@ func startChildren(parent *Process, spec *SupervisorSpec) {
 		switch spec.Children[i].state {
 		case supervisorChildStateDisabled:
 			spec.Children[i].process = nil
-		case supervisorChildStateRunning:
+		case supervisorChildStateRunning, supervisorChildStateWaiting:
 			continue
 		case supervisorChildStateStart:
 			spec.Children[i].state = supervisorChildStateRunning
 			process := startChild(parent, spec.Children[i].Name, spec.Children[i].Child, spec.Children[i].Args...)
 			spec.Children[i].process = process
+			spec.Children[i].startedAt = time.Now()
 		default:
 			panic("Incorrect supervisorChildState")
 		}
@@ -503,6 +858,79 @@ func startChild(parent *Process, name string, child interface{}, args ...interfa
 	return process
 }
 
+// shutdown returns the shutdown option of the child. Infinity is allowed
+// for the supervisor only
+func (spec SupervisorChildSpec) shutdown() SupervisorChildShutdown {
+	if spec.Shutdown != SupervisorChildShutdownInfinity {
+		return spec.Shutdown
+	}
+	if _, ok := spec.Child.(SupervisorBehaviour); ok {
+		return SupervisorChildShutdownInfinity
+	}
+	return SupervisorChildShutdownTimeout5sec
+}
+
+// shutdownChild terminates the child according to the shutdown option
+// and returns once the child is terminated (or killed)
+func shutdownChild(from etf.Pid, child *Process, shutdown SupervisorChildShutdown, reason string) {
+	switch {
+	case shutdown == SupervisorChildShutdownBrutal:
+		child.Kill()
+		return
+
+	case shutdown == SupervisorChildShutdownInfinity:
+		child.Exit(from, reason)
+		child.Wait()
+		return
+	}
+
+	child.Exit(from, reason)
+	if shutdown < 0 {
+		// unknown value. do not wait
+		return
+	}
+	if child.WaitWithTimeout(time.Duration(shutdown)*time.Second) == ErrTimeout {
+		lib.Log("child %v hasn't terminated in %d seconds. killing it", child.Self(), shutdown)
+		child.Kill()
+	}
+}
+
+// delay registers the crash of the child and returns the delay before its restart
+func (b SupervisorBackoff) delay(child *SupervisorChildSpec) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	resetAfter := b.ResetAfter
+	if resetAfter <= 0 {
+		resetAfter = 10 * b.Base
+	}
+
+	if time.Since(child.startedAt) >= resetAfter {
+		// child has been up long enough
+		child.crashes = 0
+	}
+	child.crashes++
+	if child.crashes == 1 {
+		return 0
+	}
+
+	delay := float64(b.Base)
+	for i := 2; i < child.crashes; i++ {
+		delay *= multiplier
+		if b.Max > 0 && delay >= float64(b.Max) {
+			return b.Max
+		}
+	}
+	if b.Max > 0 && delay > float64(b.Max) {
+		return b.Max
+	}
+	return time.Duration(delay)
+}
+
 func haveToDisableChild(restart SupervisorChildRestart, reason etf.Atom) bool {
 	switch restart {
 	case SupervisorChildRestartTransient:
@@ -301,3 +301,78 @@ func (ts *testSupervisorOneForAll) Init(args ...interface{}) SupervisorSpec {
 		},
 	}
 }
+
+type testSupervisorOneForAllBrutal struct {
+	Supervisor
+}
+
+func (ts *testSupervisorOneForAllBrutal) Init(args ...interface{}) SupervisorSpec {
+	ch := args[0].(chan interface{})
+	return SupervisorSpec{
+		Children: []SupervisorChildSpec{
+			SupervisorChildSpec{
+				Name:    "testGS1",
+				Child:   &testSupervisorGenServer{},
+				Restart: SupervisorChildRestartPermanent,
+				Args:    []interface{}{ch, 0},
+			},
+			SupervisorChildSpec{
+				Name:     "testGS2",
+				Child:    &testSupervisorGenServer{},
+				Restart:  SupervisorChildRestartPermanent,
+				Args:     []interface{}{ch, 1},
+				Shutdown: SupervisorChildShutdownBrutal,
+			},
+			SupervisorChildSpec{
+				Name:    "testGS3",
+				Child:   &testSupervisorGenServer{},
+				Restart: SupervisorChildRestartPermanent,
+				Args:    []interface{}{ch, 2},
+			},
+		},
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategyOneForAll,
+			Intensity: 10,
+			Period:    5,
+		},
+	}
+}
+
+func TestSupervisorOneForAllBrutal(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - one for all (brutal_kill sibling)\n")
+	fmt.Printf("Starting node nodeSvOneForAllBrutal@localhost: ")
+	node := CreateNode("nodeSvOneForAllBrutal@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	fmt.Printf("Starting supervisor 'testSupervisorOneForAllBrutal'... ")
+	sv := &testSupervisorOneForAllBrutal{}
+	ch := make(chan interface{}, 10)
+	processSV, err := node.Spawn("testSupervisorOneForAllBrutal", ProcessOptions{}, sv, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(ch, 3, make([]etf.Pid, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... killed sibling doesn't stop the supervisor: ")
+	processSV.Cast(children[0], "crash")
+	// 2 terminates (the killed one has no Terminate callback) and 3 starts
+	children1, err := waitNeventsSupervisorChildren(ch, 5, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children, children1, []string{"new", "new", "new"}) {
+		t.Fatal("unexpected children", children, children1)
+	}
+	if !processSV.IsAlive() {
+		t.Fatal("supervisor is terminated")
+	}
+	fmt.Println("OK")
+}
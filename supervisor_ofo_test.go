@@ -25,10 +25,12 @@ package ergo
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/halturin/ergo/etf"
+	"github.com/halturin/ergo/lib"
 )
 
 type testSupervisorOneForOne struct {
@@ -345,3 +347,383 @@ func checkExpectedChildrenStatus(children, children1 []etf.Pid, statuses []strin
 	}
 	return true
 }
+
+func TestSupervisorTerminateDeleteChild(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - terminate/delete child\n")
+	fmt.Printf("Starting node nodeSvTerminateChild@localhost: ")
+	node := CreateNode("nodeSvTerminateChild@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	fmt.Printf("Starting supervisor 'testSupervisorTerminateChild'... ")
+	sv := &testSupervisorOneForOne{
+		ch: make(chan interface{}, 10),
+	}
+	processSV, err := node.Spawn("testSupervisorTerminateChild", ProcessOptions{}, sv, SupervisorChildRestartPermanent, sv.ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(sv.ch, 3, make([]etf.Pid, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... running child can't be deleted: ")
+	if err := sv.DeleteChild(processSV, "testGS2"); err == nil || err.Error() != "running" {
+		t.Fatal("expected error 'running', got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... terminating child 'testGS2'. it isn't restarted: ")
+	if err := sv.TerminateChild(processSV, "testGS2"); err != nil {
+		t.Fatal(err)
+	}
+	if node.IsProcessAlive(children[1]) {
+		t.Fatal("child is still alive")
+	}
+	children1, err := waitNeventsSupervisorChildren(sv.ch, 1, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children, children1, []string{"old", "empty", "old"}) {
+		t.Fatal("unexpected children status", children, children1)
+	}
+	if !processSV.IsAlive() {
+		t.Fatal("supervisor has been terminated")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... deleting child 'testGS2': ")
+	if err := sv.DeleteChild(processSV, "testGS2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.DeleteChild(processSV, "testGS2"); err == nil || err.Error() != "not_found" {
+		t.Fatal("expected error 'not_found', got", err)
+	}
+	if err := sv.TerminateChild(processSV, "testGS2"); err == nil || err.Error() != "not_found" {
+		t.Fatal("expected error 'not_found', got", err)
+	}
+	if n := len(processSV.GetChildren()); n != 2 {
+		t.Fatal("expected 2 children, got", n)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... the rest of the children are still restarted: ")
+	processSV.Cast(children[2], "abnormal")
+	children2, err := waitNeventsSupervisorChildren(sv.ch, 2, children1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children1, children2, []string{"old", "empty", "new"}) {
+		t.Fatal("unexpected children status", children1, children2)
+	}
+	fmt.Println("OK")
+}
+
+type testSupervisorShutdown struct {
+	Supervisor
+}
+
+func (ts *testSupervisorShutdown) Init(args ...interface{}) SupervisorSpec {
+	ch := args[0].(chan interface{})
+	return SupervisorSpec{
+		Children: []SupervisorChildSpec{
+			SupervisorChildSpec{
+				Name:     "testGS1",
+				Child:    &testSupervisorGenServer{},
+				Restart:  SupervisorChildRestartPermanent,
+				Args:     []interface{}{ch, 0},
+				Shutdown: SupervisorChildShutdownBrutal,
+			},
+			SupervisorChildSpec{
+				Name:     "testGS2",
+				Child:    &testSupervisorTrapGenServer{},
+				Restart:  SupervisorChildRestartPermanent,
+				Args:     []interface{}{ch, 1},
+				Shutdown: 1,
+			},
+			SupervisorChildSpec{
+				Name:    "testGS3",
+				Child:   &testSupervisorGenServer{},
+				Restart: SupervisorChildRestartPermanent,
+				Args:    []interface{}{ch, 2},
+			},
+		},
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategyOneForOne,
+			Intensity: 10,
+			Period:    5,
+		},
+	}
+}
+
+// testSupervisorTrapGenServer ignores the exit requests
+type testSupervisorTrapGenServer struct {
+	testSupervisorGenServer
+}
+
+func (tsv *testSupervisorTrapGenServer) Init(p *Process, args ...interface{}) (state interface{}) {
+	p.SetTrapExit(true)
+	return tsv.testSupervisorGenServer.Init(p, args...)
+}
+
+func TestSupervisorShutdown(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - child shutdown\n")
+	fmt.Printf("Starting node nodeSvShutdown@localhost: ")
+	node := CreateNode("nodeSvShutdown@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	fmt.Printf("Starting supervisor 'testSupervisorShutdown'... ")
+	sv := &testSupervisorShutdown{}
+	ch := make(chan interface{}, 10)
+	processSV, err := node.Spawn("testSupervisorShutdown", ProcessOptions{}, sv, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(ch, 3, make([]etf.Pid, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... brutal_kill child is killed without termination: ")
+	if err := sv.TerminateChild(processSV, "testGS1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waitNeventsSupervisorChildren(ch, 0, children); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... child ignoring the exit request is killed once the timeout is exceeded: ")
+	started := time.Now()
+	terminated := make(chan error)
+	go func() {
+		terminated <- sv.TerminateChild(processSV, "testGS2")
+	}()
+	// supervisor keeps handling the requests while the child is terminating
+	time.Sleep(100 * time.Millisecond)
+	if _, err := sv.WhichChildren(processSV); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Fatal("supervisor is blocked by the terminating child", elapsed)
+	}
+	if err := <-terminated; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Fatal("unexpected shutdown time", elapsed)
+	}
+	if node.IsProcessAlive(children[1]) {
+		t.Fatal("child is still alive")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... worker is terminated gracefully: ")
+	if err := sv.TerminateChild(processSV, "testGS3"); err != nil {
+		t.Fatal(err)
+	}
+	children1, err := waitNeventsSupervisorChildren(ch, 1, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children, children1, []string{"old", "old", "empty"}) {
+		t.Fatal("unexpected children status", children, children1)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... default shutdown value: ")
+	spec := SupervisorChildSpec{Child: &testSupervisorGenServer{}}
+	if spec.shutdown() != SupervisorChildShutdownTimeout5sec {
+		t.Fatal("unexpected shutdown value for the worker", spec.shutdown())
+	}
+	spec = SupervisorChildSpec{Child: &testSupervisorOneForOne{}}
+	if spec.shutdown() != SupervisorChildShutdownInfinity {
+		t.Fatal("unexpected shutdown value for the supervisor", spec.shutdown())
+	}
+	fmt.Println("OK")
+}
+
+func TestSupervisorWhichChildren(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - which/count children\n")
+	fmt.Printf("Starting node nodeSvWhichChildren@localhost: ")
+	node := CreateNode("nodeSvWhichChildren@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	fmt.Printf("Starting supervisor 'testSupervisorWhichChildren'... ")
+	sv := &testSupervisorOneForOne{
+		ch: make(chan interface{}, 10),
+	}
+	processSV, err := node.Spawn("testSupervisorWhichChildren", ProcessOptions{}, sv, SupervisorChildRestartPermanent, sv.ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(sv.ch, 3, make([]etf.Pid, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... which children: ")
+	if err := sv.TerminateChild(processSV, "testGS2"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := sv.WhichChildren(processSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SupervisorChildInfo{
+		{Name: "testGS1", Pid: children[0], Status: SupervisorChildStatusRunning, Type: SupervisorChildTypeWorker},
+		{Name: "testGS2", Status: SupervisorChildStatusUndefined, Type: SupervisorChildTypeWorker},
+		{Name: "testGS3", Pid: children[2], Status: SupervisorChildStatusRunning, Type: SupervisorChildTypeWorker},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatal("expected", expected, "got", info)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... count children: ")
+	count, err := sv.CountChildren(processSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != (SupervisorChildrenCount{Specs: 3, Active: 2, Workers: 3}) {
+		t.Fatal("unexpected count", count)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... result is encodable to ETF: ")
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+	if err := etf.Encode(info, b, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+}
+
+type testSupervisorBackoff struct {
+	Supervisor
+}
+
+func (ts *testSupervisorBackoff) Init(args ...interface{}) SupervisorSpec {
+	ch := args[0].(chan interface{})
+	return SupervisorSpec{
+		Children: []SupervisorChildSpec{
+			SupervisorChildSpec{
+				Name:    "testGS1",
+				Child:   &testSupervisorGenServer{},
+				Restart: SupervisorChildRestartPermanent,
+				Args:    []interface{}{ch, 0},
+			},
+			SupervisorChildSpec{
+				Name:    "testGS2",
+				Child:   &testSupervisorGenServer{},
+				Restart: SupervisorChildRestartPermanent,
+				Args:    []interface{}{ch, 1},
+			},
+		},
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategyOneForOne,
+			Intensity: 10,
+			Period:    5,
+		},
+		Backoff: SupervisorBackoff{
+			Base:       time.Second,
+			Max:        5 * time.Second,
+			ResetAfter: 10 * time.Second,
+		},
+	}
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - restart backoff\n")
+	fmt.Printf("Starting node nodeSvBackoff@localhost: ")
+	node := CreateNode("nodeSvBackoff@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	fmt.Printf("Starting supervisor 'testSupervisorBackoff'... ")
+	sv := &testSupervisorBackoff{}
+	ch := make(chan interface{}, 10)
+	processSV, err := node.Spawn("testSupervisorBackoff", ProcessOptions{}, sv, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(ch, 2, make([]etf.Pid, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... the first crash is restarted immediately: ")
+	processSV.Cast(children[0], "crash")
+	children1, err := waitNeventsSupervisorChildren(ch, 2, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children, children1, []string{"new", "old"}) {
+		t.Fatal("unexpected children", children, children1)
+	}
+	children = children1
+	fmt.Println("OK")
+
+	fmt.Printf("... the next crash is restarted with delay: ")
+	processSV.Cast(children[0], "crash")
+	// terminated only
+	children1, err = waitNeventsSupervisorChildren(ch, 1, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children, children1, []string{"empty", "old"}) {
+		t.Fatal("unexpected children", children, children1)
+	}
+	children = children1
+	info, err := sv.WhichChildren(processSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info[0].Status != SupervisorChildStatusRestarting {
+		t.Fatal("expected restarting status, got", info[0].Status)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... other children are restarted while waiting: ")
+	processSV.Cast(children[1], "crash")
+	children1, err = waitNeventsSupervisorChildren(ch, 2, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children, children1, []string{"empty", "new"}) {
+		t.Fatal("unexpected children", children, children1)
+	}
+	children = children1
+	fmt.Println("OK")
+
+	fmt.Printf("... delayed child is restarted: ")
+	select {
+	case m := <-ch:
+		started, ok := m.(testMessageStarted)
+		if !ok || started.order != 0 {
+			t.Fatal("unexpected message", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+	fmt.Println("OK")
+}
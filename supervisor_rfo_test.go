@@ -42,7 +42,9 @@ package ergo
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/halturin/ergo/etf"
 	// "time"
@@ -301,3 +303,99 @@ func (ts *testSupervisorRestForOne) Init(args ...interface{}) SupervisorSpec {
 		},
 	}
 }
+
+type testSupervisorRestForOneOrder struct {
+	Supervisor
+}
+
+func (ts *testSupervisorRestForOneOrder) Init(args ...interface{}) SupervisorSpec {
+	ch := args[0].(chan interface{})
+	children := []SupervisorChildSpec{}
+	for i := 0; i < 4; i++ {
+		children = append(children, SupervisorChildSpec{
+			Name:    fmt.Sprintf("testGS%d", i+1),
+			Child:   &testSupervisorGenServer{},
+			Restart: SupervisorChildRestartPermanent,
+			Args:    []interface{}{ch, i},
+		})
+	}
+	// the initial start is counted as a restart as well
+	return SupervisorSpec{
+		Children: children,
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategyRestForOne,
+			Intensity: 3,
+			Period:    5,
+		},
+	}
+}
+
+func TestSupervisorRestForOneOrder(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - rest for one (order)\n")
+	fmt.Printf("Starting node nodeSvRestForOneOrder@localhost: ")
+	node := CreateNode("nodeSvRestForOneOrder@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	ch := make(chan interface{}, 20)
+	fmt.Printf("Starting supervisor 'testSupervisorRestForOneOrder'... ")
+	processSV, err := node.Spawn("testSupervisorRestForOneOrder", ProcessOptions{}, &testSupervisorRestForOneOrder{}, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(ch, 4, make([]etf.Pid, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	// order of the events: "-N" - child N terminated, "+N" - child N started
+	events := func(n int) []string {
+		result := []string{}
+		for i := 0; i < n; i++ {
+			select {
+			case e := <-ch:
+				switch m := e.(type) {
+				case testMessageTerminated:
+					result = append(result, fmt.Sprintf("-%d", m.order))
+				case testMessageStarted:
+					result = append(result, fmt.Sprintf("+%d", m.order))
+				}
+			case <-time.After(time.Second):
+				return result
+			}
+		}
+		return result
+	}
+
+	fmt.Printf("... stopping child 2. the rest is terminated in reverse order and restarted in forward order: ")
+	processSV.Cast(children[1], "abnormal")
+	expected := []string{"-1", "-3", "-2", "+1", "+2", "+3"}
+	if result := events(len(expected)); !reflect.DeepEqual(result, expected) {
+		t.Fatal("expected", expected, "got", result)
+	}
+	if !processSV.IsAlive() || !node.IsProcessAlive(children[0]) {
+		t.Fatal("supervisor or the first child has been terminated")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... stopping the last child. only this one is restarted: ")
+	last := node.GetProcessByName("testGS4")
+	processSV.Cast(last.Self(), "abnormal")
+	expected = []string{"-3", "+3"}
+	if result := events(len(expected)); !reflect.DeepEqual(result, expected) {
+		t.Fatal("expected", expected, "got", result)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... restart intensity is exceeded: ")
+	first := node.GetProcessByName("testGS1")
+	processSV.Cast(first.Self(), "abnormal")
+	if err := processSV.WaitWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+}
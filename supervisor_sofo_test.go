@@ -10,6 +10,7 @@ package ergo
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/halturin/ergo/etf"
 )
@@ -73,10 +74,10 @@ func TestSupervisorSimpleOneForOne(t *testing.T) {
 
 		// start children
 		for i := 0; i < 6; i = i + 2 {
-			p, _ := sv.StartChild(processSV, fmt.Sprintf("testGS%d", i/2+1), sv.ch, i)
+			p, _ := sv.StartChild(processSV, fmt.Sprintf("testGS%d", i/2+1), i)
 			children[i] = p
 			// start twice
-			p, _ = sv.StartChild(processSV, fmt.Sprintf("testGS%d", i/2+1), sv.ch, i+1)
+			p, _ = sv.StartChild(processSV, fmt.Sprintf("testGS%d", i/2+1), i+1)
 			children[i+1] = p
 		}
 		if children1, err := waitNeventsSupervisorChildren(sv.ch, 6, children); err != nil {
@@ -137,19 +138,19 @@ func (ts *testSupervisorSimpleOneForOne) Init(args ...interface{}) SupervisorSpe
 				Name:    "testGS1",
 				Child:   &testSupervisorGenServer{},
 				Restart: SupervisorChildRestartPermanent,
-				Args:    []interface{}{ch, 0},
+				Args:    []interface{}{ch},
 			},
 			SupervisorChildSpec{
 				Name:    "testGS2",
 				Child:   &testSupervisorGenServer{},
 				Restart: SupervisorChildRestartTransient,
-				Args:    []interface{}{ch, 1},
+				Args:    []interface{}{ch},
 			},
 			SupervisorChildSpec{
 				Name:    "testGS3",
 				Child:   &testSupervisorGenServer{},
 				Restart: SupervisorChildRestartTemporary,
-				Args:    []interface{}{ch, 2},
+				Args:    []interface{}{ch},
 			},
 		},
 		Strategy: SupervisorStrategy{
@@ -159,3 +160,173 @@ func (ts *testSupervisorSimpleOneForOne) Init(args ...interface{}) SupervisorSpe
 		},
 	}
 }
+
+type testSupervisorSimpleOneForOneArgs struct {
+	Supervisor
+}
+
+func (ts *testSupervisorSimpleOneForOneArgs) Init(args ...interface{}) SupervisorSpec {
+	ch := args[0].(chan interface{})
+	return SupervisorSpec{
+		Children: []SupervisorChildSpec{
+			SupervisorChildSpec{
+				Name:    "testGS1",
+				Child:   &testSupervisorArgsGenServer{},
+				Restart: SupervisorChildRestartTemporary,
+				Args:    []interface{}{ch, "spec1", "spec2"},
+			},
+		},
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategySimpleOneForOne,
+			Intensity: 10,
+			Period:    5,
+		},
+	}
+}
+
+// testSupervisorArgsGenServer reports the args it has been started with
+type testSupervisorArgsGenServer struct {
+	GenServer
+}
+
+func (tgs *testSupervisorArgsGenServer) Init(p *Process, args ...interface{}) interface{} {
+	args[0].(chan interface{}) <- fmt.Sprintf("%v", args[1:])
+	return nil
+}
+func (tgs *testSupervisorArgsGenServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testSupervisorArgsGenServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testSupervisorArgsGenServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testSupervisorArgsGenServer) Terminate(reason string, state interface{}) {
+}
+
+func TestSupervisorSimpleOneForOneArgs(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - simple one for one (child args)\n")
+	fmt.Printf("Starting node nodeSvSimpleOneForOneArgs@localhost: ")
+	node := CreateNode("nodeSvSimpleOneForOneArgs@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	sv := &testSupervisorSimpleOneForOneArgs{}
+	ch := make(chan interface{}, 10)
+	processSV, err := node.Spawn("testSupervisor", ProcessOptions{}, sv, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("... extra args are appended to the spec args: ")
+	if _, err := sv.StartChild(processSV, "testGS1", "extra1", "extra2"); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, ch, "[spec1 spec2 extra1 extra2]")
+
+	fmt.Printf("... the spec args are used if there are no extra args: ")
+	if _, err := sv.StartChild(processSV, "testGS1"); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, ch, "[spec1 spec2]")
+}
+
+type testSupervisorSimpleOneForOneBackoff struct {
+	Supervisor
+}
+
+func (ts *testSupervisorSimpleOneForOneBackoff) Init(args ...interface{}) SupervisorSpec {
+	return SupervisorSpec{
+		Children: []SupervisorChildSpec{
+			SupervisorChildSpec{
+				Name:    "testGS1",
+				Child:   &testSupervisorGenServer{},
+				Restart: SupervisorChildRestartPermanent,
+			},
+		},
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategySimpleOneForOne,
+			Intensity: 10,
+			Period:    5,
+		},
+		Backoff: SupervisorBackoff{
+			Base:       time.Second,
+			Max:        5 * time.Second,
+			ResetAfter: 10 * time.Second,
+		},
+	}
+}
+
+func TestSupervisorSimpleOneForOneBackoff(t *testing.T) {
+	fmt.Printf("\n=== Test Supervisor - simple one for one (restart backoff)\n")
+	fmt.Printf("Starting node nodeSvSimpleOneForOneBackoff@localhost: ")
+	node := CreateNode("nodeSvSimpleOneForOneBackoff@localhost", "cookies", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	fmt.Println("OK")
+	defer node.Stop()
+
+	fmt.Printf("Starting child... ")
+	sv := &testSupervisorSimpleOneForOneBackoff{}
+	ch := make(chan interface{}, 10)
+	processSV, err := node.Spawn("testSupervisor", ProcessOptions{}, sv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.StartChild(processSV, "testGS1", ch, 0); err != nil {
+		t.Fatal(err)
+	}
+	children, err := waitNeventsSupervisorChildren(ch, 1, make([]etf.Pid, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... the first crash is restarted immediately: ")
+	processSV.Cast(children[0], "crash")
+	children1, err := waitNeventsSupervisorChildren(ch, 2, children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkExpectedChildrenStatus(children, children1, []string{"new"}) {
+		t.Fatal("unexpected children", children, children1)
+	}
+	children = children1
+	fmt.Println("OK")
+
+	fmt.Printf("... the terminated child is not listed while waiting for the restart: ")
+	processSV.Cast(children[0], "crash")
+	if _, err := waitNeventsSupervisorChildren(ch, 1, children); err != nil {
+		t.Fatal(err)
+	}
+	if pids := processSV.GetChildren(); len(pids) != 0 {
+		t.Fatal("unexpected children", pids)
+	}
+	info, err := sv.WhichChildren(processSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info) != 1 || info[0].Status != SupervisorChildStatusRestarting || info[0].Pid != (etf.Pid{}) {
+		t.Fatal("unexpected children info", info)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... delayed child is restarted: ")
+	select {
+	case m := <-ch:
+		if _, ok := m.(testMessageStarted); !ok {
+			t.Fatal("unexpected message", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+	if pids := processSV.GetChildren(); len(pids) != 1 {
+		t.Fatal("unexpected children", pids)
+	}
+	fmt.Println("OK")
+}
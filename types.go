@@ -1,6 +1,7 @@
 package ergo
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -18,6 +19,14 @@ var (
 	ErrTimeout            = fmt.Errorf("Timed out")
 	ErrFragmented         = fmt.Errorf("Fragmented data")
 	ErrStop               = fmt.Errorf("stop")
+	ErrSelfCall           = fmt.Errorf("Self call is not allowed")
+	ErrMailboxFull        = fmt.Errorf("Mailbox is full")
+	ErrNoDirectDispatch   = fmt.Errorf("DirectDispatch is required")
+	ErrNodeStopping       = fmt.Errorf("Node is stopping")
+	ErrGroupNotMember     = fmt.Errorf("Process is not a member of the group")
+	ErrGroupRemoteProcess = fmt.Errorf("Only local process can join the group")
+	ErrGroupDisabled      = fmt.Errorf("Process groups are disabled")
+	ErrGroupNameTaken     = fmt.Errorf("Reserved name 'pg' is taken by another process. Process groups are disabled")
 )
 
 // Distributed operations codes (http://www.erlang.org/doc/apps/erts/erl_dist_protocol.html)
@@ -52,9 +61,36 @@ type peer struct {
 	i    int
 	n    int
 
+	// the send channels are never closed (there could be the senders).
+	// the writers are stopped by canceling this context instead
+	context context.Context
+	cancel  context.CancelFunc
+
 	mutex sync.Mutex
 }
 
+// Send sends the message to the peer using the next send channel.
+// Returns false if the peer has been closed.
+func (p *peer) Send(message []etf.Term) bool {
+	select {
+	case <-p.context.Done():
+		return false
+	default:
+	}
+
+	select {
+	case p.GetChannel() <- message:
+		return true
+	case <-p.context.Done():
+		return false
+	}
+}
+
+// Close stops the writers and unblocks the senders
+func (p *peer) Close() {
+	p.cancel()
+}
+
 func (p *peer) GetChannel() chan []etf.Term {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()